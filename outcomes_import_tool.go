@@ -1,30 +1,197 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
 )
 
 const (
-	Version    string = "1.1.0"
-	ConfigFile string = ".outcomes-import-tool.json"
+	ConfigFile     string        = ".outcomes-import-tool.json"
+	IndexFile      string        = ".outcomes-import-tool-index.json"
+	KeychainName   string        = "outcomes-import-tool"
+	BatchFile      string        = ".outcomes-import-tool-batch.json"
+	WatchInterval  time.Duration = 10 * time.Second // the default -interval
+	WatchTimeout   time.Duration = 30 * time.Minute // the default -watch-timeout
+	CacheTTL       time.Duration = time.Hour        // the default -cache-ttl
+	DefaultProfile string        = "default"
 )
 
+// version is stamped at build time with -ldflags "-X main.version=1.2.0"
+var version = "dev"
+
 type config struct {
-	Apikey      string           `json:"apikey"`
-	MigrationId int              `json:"migration_id"`
-	Domain      string           `json:"domain"`
-	Guids       []importableGuid `json:"guids"`
+	Apikey         string           `json:"apikey"`
+	MigrationId    int              `json:"migration_id"`
+	LastGuid       string           `json:"last_guid,omitempty"`
+	Domain         string           `json:"domain"`
+	DefaultAccount string           `json:"default_account,omitempty"`
+	DefaultScope   string           `json:"default_scope,omitempty"`
+	Guids          []importableGuid `json:"guids"`
+	// the URL Guids were fetched from and its ETag, for conditional requests,
+	// and when they were last fetched or found unchanged, in RFC 3339
+	AvailableUrl  string         `json:"available_url,omitempty"`
+	AvailableEtag string         `json:"available_etag,omitempty"`
+	AvailableAt   string         `json:"available_at,omitempty"`
+	History       []historyEntry `json:"history,omitempty"`
+	// a duration like "45s", saved from the last -timeout given
+	Timeout string `json:"timeout,omitempty"`
+	// sent as the User-Agent instead of the default, unless -user-agent is given
+	UserAgent string `json:"user_agent,omitempty"`
+	// prepended to every endpoint when Canvas is mounted under a path, unless
+	// -base-path is given
+	BasePath string `json:"base_path,omitempty"`
+	// named profiles selected with -profile.  The fields above are the
+	// default profile, which is also how older config files are read.
+	Profiles map[string]*config `json:"profiles,omitempty"`
+}
+
+// historyEntry records a scheduled import.  WorkflowState is the last state
+// the tool saw, and is empty until the migration's status has been checked.
+type historyEntry struct {
+	MigrationId   int       `json:"migration_id"`
+	Guid          string    `json:"guid"`
+	Title         string    `json:"title,omitempty"`
+	Domain        string    `json:"domain"`
+	WorkflowState string    `json:"workflow_state,omitempty"`
+	ImportedAt    time.Time `json:"imported_at"`
+}
+
+// indexEntry is a named import recorded in the index file
+type indexEntry struct {
+	MigrationId int    `json:"migration_id"`
+	Domain      string `json:"domain"`
+	Guid        string `json:"guid"`
+}
+
+// batchState records the progress of an import of several GUIDs, so that it
+// can be resumed if it's interrupted.  Completed maps each GUID (or title) as
+// it was given to the ID of the migration that imported it.
+type batchState struct {
+	Domain    string         `json:"domain"`
+	Completed map[string]int `json:"completed"`
+}
+
+// batchResult is the outcome of importing one GUID (or title) of a batch
+type batchResult struct {
+	Entry       string `json:"entry"`
+	Guid        string `json:"guid"`
+	MigrationId int    `json:"migration_id"`
+	Succeeded   bool   `json:"succeeded"`
+	Error       string `json:"error,omitempty"`
+	// the HTTP status of Canvas's response when it's what failed
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+// batchSummary is printed at the end of a batch import
+type batchSummary struct {
+	Attempted      int           `json:"attempted"`
+	Succeeded      int           `json:"succeeded"`
+	Failed         int           `json:"failed"`
+	Skipped        int           `json:"skipped"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+	Results        []batchResult `json:"results"`
+}
+
+func (s *batchSummary) add(result batchResult) {
+	result.Succeeded = result.Error == ""
+	s.Attempted++
+	if result.Succeeded {
+		s.Succeeded++
+	} else {
+		s.Failed++
+	}
+	countImportResult(result.Succeeded)
+	s.Results = append(s.Results, result)
+}
+
+// runMetrics are written in the Prometheus textfile format by -metrics-file
+type runMetrics struct {
+	ImportsAttempted int
+	ImportsSucceeded int
+	ImportsFailed    int
+	Requests         int
+	RequestSeconds   float64
+	Retries          int
+}
+
+var metrics runMetrics
+
+// metricsLock guards metrics and fixtureCount, which are updated by requests
+// made concurrently
+var metricsLock sync.Mutex
+var metricsFile = ""
+
+// countImport records an import in the metrics when it's started, and
+// countImportResult once it has succeeded or failed
+func countImport() {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics.ImportsAttempted++
+}
+
+func countImportResult(succeeded bool) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if succeeded {
+		metrics.ImportsSucceeded++
+	} else {
+		metrics.ImportsFailed++
+	}
+}
+
+// writeToFile writes the metrics to a temporary file that is renamed into
+// place, so a textfile collector never reads a partial file
+func (m runMetrics) writeToFile(path string) error {
+	var b bytes.Buffer
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP outcomes_import_%s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE outcomes_import_%s %s\n", name, kind)
+		fmt.Fprintf(&b, "outcomes_import_%s %v\n", name, value)
+	}
+	metric("imports_attempted", "gauge", "Imports attempted in the last run.", m.ImportsAttempted)
+	metric("imports_succeeded", "gauge", "Imports that succeeded in the last run.", m.ImportsSucceeded)
+	metric("imports_failed", "gauge", "Imports that failed in the last run.", m.ImportsFailed)
+	metric("request_retries", "gauge", "HTTP requests retried in the last run.", m.Retries)
+	fmt.Fprintf(&b, "# HELP outcomes_import_request_duration_seconds Duration of HTTP requests in the last run.\n")
+	fmt.Fprintf(&b, "# TYPE outcomes_import_request_duration_seconds summary\n")
+	fmt.Fprintf(&b, "outcomes_import_request_duration_seconds_sum %v\n", m.RequestSeconds)
+	fmt.Fprintf(&b, "outcomes_import_request_duration_seconds_count %d\n", m.Requests)
+	metric("last_run_timestamp_seconds", "gauge", "When the last run finished.", time.Now().Unix())
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 type request struct {
@@ -33,6 +200,65 @@ type request struct {
 	Domain   string
 	Method   string
 	Endpoint string
+	Account  string
+	Course   string
+	Cookies  []*http.Cookie
+	// print the HTTP status line of each response
+	ShowStatus bool
+	// the number of times to retry a request that gets a response with one
+	// of RetryStatuses, or any 5xx status when RetryStatuses is empty
+	Retries       int
+	RetryStatuses []int
+	// extra headers to send with each request
+	Headers http.Header
+	// identifies the tool to Canvas, defaulting to userAgent()
+	UserAgent string
+	// the path Canvas is mounted under, like "/canvas", or "" for the root
+	BasePath string
+	// how long the cached available GUIDs are used without asking Canvas
+	// whether they've changed, which is always asked when it's 0
+	CacheTTL time.Duration
+	// print each request and response's headers, masking those in Redact
+	Debug  bool
+	Redact []string
+	// directory to write each request and response to as a fixture
+	FixtureDir string
+	// print the URL of the first request and exit instead of sending it
+	PrintEndpoint bool
+	// sends the requests instead of an *http.Client built for each one
+	Client Doer
+	// the transport of the *http.Client, with the proxy and TLS settings
+	Transport http.RoundTripper
+	// one import of a batch, whose results are only printed in the batch
+	// summary with -json, so stdout holds a single JSON document
+	InBatch bool
+	// print the imports that would be requested instead of requesting them
+	DryRun bool
+	// ask before requesting each import
+	Confirm bool
+	// asks for a new API key when the key is rejected, or nil to not ask
+	Reprompt *apikeyReprompt
+	// how long to wait for a response.  ReadTimeout applies to GETs and
+	// WriteTimeout to everything else, with Timeout used when they're zero
+	Timeout      time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// fixture is a request and the response it received, as written by
+// -dump-fixture.  Headers in the request's Redact list are masked.
+type fixture struct {
+	Request struct {
+		Method   string      `json:"method"`
+		Endpoint string      `json:"endpoint"`
+		Headers  http.Header `json:"headers"`
+		Body     string      `json:"body"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int         `json:"status_code"`
+		Headers    http.Header `json:"headers"`
+		Body       string      `json:"body"`
+	} `json:"response"`
 }
 
 type importableGuid struct {
@@ -54,22 +280,105 @@ type migrationStatus struct {
 	WorkflowState        string           `json:"workflow_state"`
 	MigrationIssuesCount int              `json:"migration_issues_count"`
 	MigrationIssues      []migrationIssue `json:"migration_issues"`
-	Errors               []apiError       `json:"errors"`
+	// RFC 3339 timestamps, which aren't returned by every Canvas version
+	CreatedAt  string `json:"created_at,omitempty"`
+	UpdatedAt  string `json:"updated_at,omitempty"`
+	FinishedAt string `json:"finished_at,omitempty"`
 }
 
 type newImport struct {
-	MigrationId int        `json:"migration_id"`
-	Guid        string     `json:"guid"`
-	Errors      []apiError `json:"errors"`
-	Error       string     `json:"errors"`
+	MigrationId int    `json:"migration_id"`
+	Guid        string `json:"guid"`
+	ProgressUrl string `json:"progress_url"`
+	// the title of Guid, when it's known.  Canvas doesn't return it.
+	Title string `json:"title,omitempty"`
+}
+
+// progressStatus is a Canvas Progress object, which tracks an asynchronous job
+type progressStatus struct {
+	Id            int     `json:"id"`
+	WorkflowState string  `json:"workflow_state"`
+	Completion    float64 `json:"completion"`
+	Message       string  `json:"message"`
+}
+
+type contentMigration struct {
+	Id            int    `json:"id"`
+	MigrationType string `json:"migration_type"`
+	WorkflowState string `json:"workflow_state"`
+	CreatedAt     string `json:"created_at"`
+}
+
+type outcomeGroup struct {
+	Id         int    `json:"id"`
+	Title      string `json:"title"`
+	VendorGuid string `json:"vendor_guid"`
+}
+
+type account struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// canvasUser is the user an API key belongs to
+type canvasUser struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// canvasError captures the various shapes of Canvas error responses:
+//
+//	{"errors": [{"message": "..."}]}
+//	{"errors": {"guid": [{"attribute": "guid", "message": "..."}]}}
+//	{"errors": "..."}, {"error": "..."} or {"message": "..."}
+type canvasError struct {
+	Errors  interface{} `json:"errors"`
+	Error   string      `json:"error"`
+	Message string      `json:"message"`
 }
 
-type apiErrors struct {
-	Errors []apiError `json:"errors"`
+// errorMessages returns every human-readable message in body if it is a Canvas
+// error response, or nil if it isn't.
+func errorMessages(body []byte) []string {
+	var cerr canvasError
+	if err := json.Unmarshal(body, &cerr); err != nil {
+		return nil
+	}
+	var messages []string
+	collectMessages(cerr.Errors, "", &messages)
+	if cerr.Error != "" {
+		messages = append(messages, cerr.Error)
+	}
+	if cerr.Message != "" {
+		messages = append(messages, cerr.Message)
+	}
+	return messages
 }
 
-type apiError struct {
-	Message string `json:"message"`
+// collectMessages walks the decoded "errors" value of a Canvas error response.
+// Messages nested under an attribute name are prefixed with it.
+func collectMessages(v interface{}, prefix string, messages *[]string) {
+	switch v := v.(type) {
+	case string:
+		*messages = append(*messages, prefix+v)
+	case []interface{}:
+		for _, e := range v {
+			collectMessages(e, prefix, messages)
+		}
+	case map[string]interface{}:
+		if message, ok := v["message"].(string); ok {
+			*messages = append(*messages, prefix+message)
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectMessages(v[key], key+": ", messages)
+		}
+	}
 }
 
 func fatalExit(message ...interface{}) {
@@ -78,12 +387,130 @@ func fatalExit(message ...interface{}) {
 	for i, m := range message {
 		errmessage[i+1] = m
 	}
+	if jsonOutput {
+		printJson(jsonError{Error: strings.TrimSpace(fmt.Sprintln(message...))})
+		exit(ExitFailure)
+	}
 	fmt.Fprintln(os.Stderr, errmessage...)
-	os.Exit(1)
+	exit(ExitFailure)
+}
+
+// Exit codes.  flag exits with 2 for invalid flags.
+const (
+	ExitFailure         = 1   // an import or migration failed, or the tool was misused
+	ExitRequestError    = 3   // a request to Canvas failed, or its response wasn't understood
+	ExitMigrationFailed = 4   // -status found a migration that failed
+	ExitMigrationIssues = 5   // -status found a migration that finished with migration issues
+	ExitWatchTimedOut   = 6   // -watch gave up waiting for a migration after -watch-timeout
+	ExitInterrupted     = 130 // the tool was interrupted with Ctrl-C or SIGTERM
+)
+
+// requestFailed reports an error from a request to Canvas and exits
+func requestFailed(err error) {
+	printError(err.Error(), statusCode(err))
+	exit(ExitRequestError)
+}
+
+// jsonError is printed for a failure with -json, so scripts can tell it apart
+// from a result.  StatusCode is 0 when the failure wasn't Canvas's response.
+type jsonError struct {
+	Error      string `json:"error"`
+	StatusCode int    `json:"status_code"`
+}
+
+// printError reports a failure on stderr, or as a jsonError with -json
+func printError(message string, statusCode int) {
+	if jsonOutput {
+		printJson(jsonError{Error: message, StatusCode: statusCode})
+		return
+	}
+	fmt.Fprintln(os.Stderr, "\n[-]", message)
+}
+
+// responseError is an error response from Canvas, which keeps its status code
+type responseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// statusCode returns the HTTP status of the Canvas response err came from, or
+// 0 if it didn't come from one
+func statusCode(err error) int {
+	var rerr *responseError
+	if errors.As(err, &rerr) {
+		return rerr.StatusCode
+	}
+	return 0
+}
+
+// userAgent identifies the tool and its version to Canvas, so admins can tell
+// its API traffic apart
+func userAgent() string {
+	return "outcomes-import-tool/" + version
+}
+
+// ctx is cancelled when the tool is interrupted, which aborts the request in
+// flight.  main replaces it with one that's cancelled by SIGINT or SIGTERM.
+var ctx = context.Background()
+
+// AbortGrace is how long an interrupted request has to unwind before the tool
+// exits anyway, e.g. when it was waiting for input rather than a request
+const AbortGrace = time.Second
+
+// aborted exits because the tool was interrupted
+func aborted() {
+	fmt.Fprintln(os.Stderr, "\n[-] Aborted by user")
+	exit(ExitInterrupted)
+}
+
+// sleep waits for d, or aborts if the tool is interrupted first
+func sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+		aborted()
+	}
+}
+
+// exit writes the -metrics-file, if any, before exiting
+func exit(code int) {
+	if metricsFile != "" {
+		if code != 0 {
+			// an import that was cut short by the error counts as failed
+			metrics.ImportsFailed = metrics.ImportsAttempted - metrics.ImportsSucceeded
+		}
+		if err := metrics.writeToFile(metricsFile); err != nil {
+			fmt.Fprintln(os.Stderr, "[-] Unable to write metrics:", err)
+		}
+	}
+	os.Exit(code)
 }
 
+// profile is set by -profile to the name of the profile in the config file
+// to use
+var profile = DefaultProfile
+
+// configFromFile returns the active profile of the config file, or nil if
+// there isn't a config file yet
 func configFromFile() *config {
+	root := rootConfigFromFile()
+	if root == nil || profile == DefaultProfile {
+		return root
+	}
+	if cf := root.Profiles[profile]; cf != nil {
+		return cf
+	}
+	return &config{}
+}
+
+// rootConfigFromFile returns the whole config file, with every profile
+func rootConfigFromFile() *config {
 	if f, err := os.Open(configFile()); err == nil {
+		defer f.Close()
 		var cf config
 		if err := json.NewDecoder(f).Decode(&cf); err != nil {
 			fatalExit("Config file json error:", err)
@@ -97,27 +524,403 @@ func configFromFile() *config {
 	}
 }
 
+// currentConfig returns the config from the config file, or an empty one if
+// there isn't a config file yet.  Changes to it can be saved with writeToFile.
+func currentConfig() *config {
+	if cf := configFromFile(); cf != nil {
+		return cf
+	}
+	return &config{}
+}
+
+// setHistoryState records the workflow state of a migration in the history
+func (c *config) setHistoryState(mstatus migrationStatus) {
+	for i := range c.History {
+		if c.History[i].MigrationId == mstatus.Id {
+			c.History[i].WorkflowState = mstatus.WorkflowState
+		}
+	}
+}
+
+func updateHistoryState(mstatus migrationStatus) {
+	updateConfig(func(cf *config) {
+		cf.setHistoryState(mstatus)
+	})
+}
+
+// configLock serializes changes to the config file, which the imports of a
+// batch each record themselves in as they run
+var configLock sync.Mutex
+
+// updateConfig reads the active profile, passes it to change, and writes it
+// back, without a change made by another goroutine in between being lost
+func updateConfig(change func(cf *config)) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	cf := currentConfig()
+	change(cf)
+	cf.writeToFile()
+}
+
+func titleForGuid(guids []importableGuid, guid string) string {
+	for _, g := range guids {
+		if strings.EqualFold(g.Guid, guid) {
+			if g.Title == "" {
+				return g.Description
+			}
+			return g.Title
+		}
+	}
+	return ""
+}
+
+// pruneHistory removes finished migrations from the history.  With keep, only
+// those older than the keep most recent are removed, and with olderThan, only
+// those imported longer ago than that.  The most recent migration and any that
+// may still be running are always kept.
+func pruneHistory(keep int, olderThan time.Duration) {
+	cf := currentConfig()
+	if keep < 1 {
+		keep = 1
+	}
+	var kept []historyEntry
+	for i, h := range cf.History {
+		recent := len(cf.History)-i <= keep
+		old := olderThan == 0 || time.Since(h.ImportedAt) > olderThan
+		if recent || !old || !isTerminalState(h.WorkflowState) {
+			kept = append(kept, h)
+		}
+	}
+	fmt.Printf("[+] Pruned %d of %d migrations from the history\n", len(cf.History)-len(kept), len(cf.History))
+	cf.History = kept
+	cf.writeToFile()
+}
+
+// refreshTitles updates the titles in the history to the current titles of
+// the available GUIDs, for when standards are renamed
+func refreshTitles(req request) {
+	guids, err := getAvailable(&req)
+	if err != nil {
+		requestFailed(err)
+	}
+	cf := currentConfig()
+	updated := 0
+	for i, h := range cf.History {
+		if title := titleForGuid(guids, h.Guid); title != "" && title != h.Title {
+			cf.History[i].Title = title
+			updated++
+		}
+	}
+	cf.writeToFile()
+	fmt.Printf("[+] Updated the titles of %d of %d migrations in the history\n", updated, len(cf.History))
+}
+
+func printHistory(history []historyEntry) {
+	if len(history) == 0 {
+		fmt.Fprintln(output, "\nNo migrations in the history")
+		return
+	}
+	fmt.Fprintf(output, "\nMigration history:\n\n")
+	for _, h := range history {
+		state := h.WorkflowState
+		if state == "" {
+			state = "unknown"
+		}
+		printRow(h.MigrationId, state, h.Guid, h.Title, h.Domain, h.ImportedAt.Format(time.RFC3339))
+	}
+}
+
+// writeConfigFile writes b to the config file, which can hold the API key, so
+// only the owner can read it.  WriteFile only sets the mode of new files, so
+// files created by older versions with a looser mode are tightened here.
+func writeConfigFile(b []byte) {
+	os.MkdirAll(filepath.Dir(configFile()), 0700)
+	// written to a temporary file and renamed over the config file, so it's
+	// never read half-written
+	tmp := configFile() + ".tmp"
+	ioutil.WriteFile(tmp, b, 0600)
+	os.Chmod(tmp, 0600)
+	os.Rename(tmp, configFile())
+}
+
 func writeBlankConfigFile() {
 	c := &config{}
 	b, _ := json.MarshalIndent(*c, "", "  ")
-	ioutil.WriteFile(configFile(), b, 0600)
+	writeConfigFile(b)
 }
 
 func (c *config) writeToFile() {
 	current := configFromFile()
-	// we only want to store the API key if the user already stores it
+	// we only want to store the API key if the user already stores it, and
+	// never one that was only given for this run
 	if current == nil || current.Apikey == "" {
 		c.Apikey = ""
+	} else if apikeyUnsaved {
+		c.Apikey = current.Apikey
+	}
+	c.save()
+}
+
+// apikeyUnsaved is set when the API key was given for this run only, with
+// -apikey-stdin, so writeToFile keeps whatever key was already saved
+var apikeyUnsaved bool
+
+func saveApikey(apikey string) {
+	c := currentConfig()
+	c.Apikey = apikey
+	c.save()
+}
+
+// save writes c to the config file as the active profile, leaving the other
+// profiles as they are
+func (c *config) save() {
+	root := rootConfigFromFile()
+	if root == nil {
+		root = &config{}
 	}
-	b, err := json.MarshalIndent(*c, "", "  ")
+	if profile == DefaultProfile {
+		c.Profiles = root.Profiles
+		root = c
+	} else {
+		if root.Profiles == nil {
+			root.Profiles = map[string]*config{}
+		}
+		c.Profiles = nil
+		root.Profiles[profile] = c
+	}
+	b, err := json.MarshalIndent(*root, "", "  ")
 	if err != nil {
 		fatalExit("Error writing to", configFile())
 	}
-	ioutil.WriteFile(configFile(), b, 0600)
+	writeConfigFile(b)
+}
+
+// homeDir returns the user's home directory: $HOME on Unix and %USERPROFILE%
+// on Windows.  It's empty if there isn't one, which leaves the index and batch
+// files in the current directory.
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home
 }
 
+// configPath is set by -config to override where the config file is kept
+var configPath = ""
+
+// configFile returns the path of the config file: -config if given, then the
+// original $HOME/.outcomes-import-tool.json if it exists, and otherwise
+// outcomes-import-tool/config.json under $XDG_CONFIG_HOME or $HOME/.config
 func configFile() string {
-	return fmt.Sprintf("%s/%s", os.Getenv("HOME"), ConfigFile)
+	if configPath != "" {
+		return configPath
+	}
+	home := homeDir()
+	if home != "" {
+		legacy := filepath.Join(home, ConfigFile)
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home == "" {
+			fatalExit("There's no home directory, and XDG_CONFIG_HOME isn't set, so there's nowhere to keep the config file.  Use -config to give its path")
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "outcomes-import-tool", "config.json")
+}
+
+func indexFile() string {
+	return filepath.Join(homeDir(), IndexFile)
+}
+
+func indexFromFile() map[string]indexEntry {
+	index := map[string]indexEntry{}
+	if f, err := os.Open(indexFile()); err == nil {
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&index); err != nil {
+			fatalExit("Index file json error:", err)
+		}
+	}
+	return index
+}
+
+func recordInIndex(name string, entry indexEntry) {
+	index := indexFromFile()
+	index[name] = entry
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		fatalExit("Error writing to", indexFile())
+	}
+	ioutil.WriteFile(indexFile(), b, 0600)
+}
+
+func batchStateFile() string {
+	return filepath.Join(homeDir(), BatchFile)
+}
+
+func batchStateFromFile() *batchState {
+	f, err := os.Open(batchStateFile())
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var state batchState
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		fatalExit("Batch state file json error:", err)
+	}
+	if state.Completed == nil {
+		state.Completed = map[string]int{}
+	}
+	return &state
+}
+
+func (s *batchState) writeToFile() {
+	b, err := json.MarshalIndent(*s, "", "  ")
+	if err != nil {
+		fatalExit("Error writing to", batchStateFile())
+	}
+	ioutil.WriteFile(batchStateFile(), b, 0600)
+}
+
+// pending returns the entries that weren't already imported by the batch
+// being resumed
+func (s *batchState) pending(entries []string) []string {
+	var pending []string
+	for _, entry := range entries {
+		if migrationId, ok := s.Completed[entry]; ok {
+			progress("[+] Skipping \"%s\", which was already imported as migration %d\n", entry, migrationId)
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	return pending
+}
+
+// usesDefaultAccount reports whether the account saved in cf applies, which it
+// doesn't when -account, -course or -global was passed
+func usesDefaultAccount(cf *config, account string, course string, global bool) bool {
+	return account == "" && course == "" && !global && cf.DefaultAccount != "" && cf.DefaultScope != "global"
+}
+
+// importTarget is an account or course to import into
+type importTarget struct {
+	Account string
+	Course  string
+}
+
+func (t importTarget) String() string {
+	if t.Course != "" {
+		return "course " + t.Course
+	}
+	return "account " + t.Account
+}
+
+// importTargets returns the targets in comma separated lists of accounts and courses
+func importTargets(accounts string, courses string) []importTarget {
+	var targets []importTarget
+	for _, id := range splitList(accounts) {
+		targets = append(targets, importTarget{Account: id})
+	}
+	for _, id := range splitList(courses) {
+		targets = append(targets, importTarget{Course: id})
+	}
+	return targets
+}
+
+// importIntoTargets resolves guid once and imports it into each of targets
+func importIntoTargets(req request, guid string, targets []importTarget, calcMethod string, calcInt int, masteryPoints int, pointsPossible int, ratings Ratings) {
+	guid, err := resolveGuid(req, guid)
+	if err != nil {
+		fatalExit(err)
+	}
+	migrationIds := make([]interface{}, len(targets))
+	failed := false
+	for i, target := range targets {
+		progress("\n[+] Importing %s into %s\n", guid, target)
+		req.Account = target.Account
+		req.Course = target.Course
+		countImport()
+		nimport, err := importGuid(req, guid, calcMethod, calcInt, masteryPoints, pointsPossible, ratings)
+		countImportResult(err == nil)
+		if err != nil {
+			warn("\n[-] %s\n", err)
+			migrationIds[i] = "failed"
+			failed = true
+			continue
+		}
+		migrationIds[i] = nimport.MigrationId
+	}
+	fmt.Printf("\nMigrations of %s:\n\n", guid)
+	for i, target := range targets {
+		printRow(target, migrationIds[i])
+	}
+	if failed {
+		exit(ExitFailure)
+	}
+}
+
+// dedupeEntries drops the entries that resolve to the same GUID as an earlier one
+func dedupeEntries(req request, entries []string) []string {
+	seen := map[string]string{}
+	var unique []string
+	for _, entry := range entries {
+		// an entry that can't be resolved is kept, to fail when it's imported
+		guid, err := resolveGuid(req, entry)
+		if err != nil {
+			guid = entry
+		}
+		if first, ok := seen[guid]; ok {
+			warn("[-] Skipping \"%s\", which is the same GUID as \"%s\".  Use -allow-duplicates to import it again\n", entry, first)
+			continue
+		}
+		seen[guid] = entry
+		unique = append(unique, entry)
+	}
+	return unique
+}
+
+// readGuidFile reads the GUIDs or titles in path, one per line, ignoring blank
+// lines and comments starting with #
+func readGuidFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// splitLists splits each of lists with splitList, for a flag that can be given
+// several times
+func splitLists(lists []string) []string {
+	var entries []string
+	for _, list := range lists {
+		entries = append(entries, splitList(list)...)
+	}
+	return entries
 }
 
 type Rating struct {
@@ -146,16 +949,149 @@ func (r *Ratings) Set(value string) error {
 
 var ratingsFlag Ratings
 
+type Cookies []*http.Cookie
+
+func (c *Cookies) String() string {
+	return fmt.Sprint(*c)
+}
+
+func (c *Cookies) Set(value string) error {
+	var parts = strings.SplitN(value, "=", 2)
+	if len(parts) == 1 || parts[0] == "" {
+		return errors.New("Cookie must be in the form name=value")
+	}
+	*c = append(*c, &http.Cookie{Name: parts[0], Value: parts[1]})
+	return nil
+}
+
+var cookiesFlag Cookies
+
+type Headers http.Header
+
+func (h *Headers) String() string {
+	return fmt.Sprint(*h)
+}
+
+func (h *Headers) Set(value string) error {
+	var parts = strings.SplitN(value, ":", 2)
+	if len(parts) == 1 || strings.TrimSpace(parts[0]) == "" {
+		return errors.New("Header must be in the form \"Name: value\"")
+	}
+	if *h == nil {
+		*h = Headers{}
+	}
+	http.Header(*h).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+var headersFlag Headers
+var secretHeadersFlag Headers
+
+type StringList []string
+
+func (l *StringList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *StringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// DefaultRedactedHeaders are always masked in -debug output
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+var redactFlag StringList
+var guidsFlag StringList
+
+// bareStatusArgs gives -status an empty value when it's used without an ID,
+// like "-status -name spring", so the flag after it isn't taken as the ID.  A
+// negative number after it is still the ID, to be rejected as one.
+func bareStatusArgs(args []string) []string {
+	fixed := make([]string, len(args))
+	for i, arg := range args {
+		fixed[i] = arg
+		bare := i+1 == len(args)
+		if !bare && strings.HasPrefix(args[i+1], "-") {
+			_, err := strconv.Atoi(strings.SplitN(args[i+1], ",", 2)[0])
+			bare = err != nil
+		}
+		if (arg == "-status" || arg == "--status") && bare {
+			fixed[i] = arg + "="
+		}
+	}
+	return fixed
+}
+
+// parseMigrationIds parses the comma separated migration IDs given to -status.
+// 0 means "unset" for status, so an explicit 0 has to be caught here.
+func parseMigrationIds(list string) ([]int, error) {
+	var ids []int
+	for _, part := range splitList(list) {
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("Migration ID must be a positive integer, got %s", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 func main() {
 	var apikey = flag.String("apikey", "", "Canvas API key")
+	var apikeyStdin = flag.Bool("apikey-stdin", false, "Read the Canvas API key from stdin, without echoing it if stdin is a terminal.  It's used for this run only and never saved")
+	var apikeyFile = flag.String("apikey-file", "", "Read the Canvas API key from this file, e.g. a mounted secret.  Defaults to $CANVAS_API_KEY_FILE")
 	var domain = flag.String(
 		"domain",
 		"",
 		"The domain.  You can just say the school name if they have a \"<school>.instructure.com\" domain, or 'localhost'",
 	)
-	var status = flag.Int("status", 0, "migration ID to check status")
+	var statusFlag = flag.String("status", "", "migration ID to check status.  Several IDs separated by commas check each of them, and with -watch, wait for all of them to finish.  Without an ID, e.g. -status -name <name>, the migration recorded under -name, or the most recent one, is checked")
 	var available = flag.Bool("available", false, "Check available migration IDs")
-	var guid = flag.String("guid", "", "GUID to schedule for import")
+	var cacheTTL = flag.Duration("cache-ttl", CacheTTL, "How long to use the cached list of available GUIDs, e.g. to resolve titles, before checking whether it has changed")
+	var refresh = flag.Bool("refresh", false, "Check whether the available GUIDs have changed, even if the cached list is newer than -cache-ttl")
+	var validateGuid = flag.String("validate-guid", "", "Check that this is a valid GUID (e.g. 'A833C528-901A-11DF-A622-0C4ED4E5D7F8') without contacting Canvas, exiting 0 if it is and 1 if not")
+	var delimiterFlag = flag.String("delimiter", "", "Separate the fields of listings with this instead of ' - ', e.g. '\\t' for a tab.  Fields containing it are quoted")
+	var noColor = flag.Bool("no-color", false, "Don't color the workflow states.  Color is also off when stdout isn't a terminal or NO_COLOR is set")
+	var wrapOutput = flag.Bool("wrap-output", true, "Wrap long issue descriptions and error messages to the width of the terminal (or 80 columns when not a terminal)")
+	var history = flag.Bool("history", false, "List the migrations this tool has scheduled")
+	var sinceMigration = flag.Int("since-migration", 0, "Only applies with -history and -list-migrations.  Only list migrations with a higher ID than this one")
+	var refreshTitlesFlag = flag.Bool("refresh-titles", false, "Update the titles in the history to the current titles of the available GUIDs")
+	var prune = flag.Bool("prune-history", false, "Remove finished migrations from the history.  Requires -keep or -older-than")
+	var keep = flag.Int("keep", 0, "Only applies with -prune-history.  Keep this many of the most recent migrations")
+	var olderThan = flag.Duration("older-than", 0, "Only applies with -prune-history.  Only remove migrations imported longer ago than this (e.g. '720h')")
+	var compare = flag.String("compare-migrations", "", "Two migration IDs separated by a comma (e.g. \"35,42\") whose states and issues should be compared")
+	var account = flag.String("account", "", "Account ID to scope operations to, instead of the global outcomes.  It's saved in the config file for later runs.  Several IDs separated by commas import -guid into each account")
+	var course = flag.String("course", "", "Course ID to scope operations to.  Several IDs separated by commas import -guid into each course")
+	var global = flag.Bool("global", false, "Use the global outcomes instead of the account saved in the config file, and forget that account")
+	var unique = flag.Bool("unique", false, "Only applies with -available.  List only the first of the GUIDs with the same title")
+	var filter = flag.String("filter", "", "Only applies with -available.  Only list the GUIDs with titles containing this, ignoring case")
+	var countOnly = flag.Bool("count-only", false, "Only applies with -available.  Print just the number of available GUIDs")
+	var format = flag.String("format", "text", "Output format for the list of available GUIDs and the summary of a batch import: 'text', 'markdown', 'csv' or 'json'.  'json' also applies to the results of imports and statuses")
+	var jsonFlag = flag.Bool("json", false, "Short for -format json")
+	var csvFlag = flag.Bool("csv", false, "Short for -format csv")
+	var outputPath = flag.String("output", "", "Write the results (the list of GUIDs, statuses, or import results) to this file instead of stdout, with the progress messages on stderr")
+	var saveReport = flag.String("save-report", "", "Only applies with -status.  Download the error report of each migration issue that has one into this directory")
+	var cancel = flag.Int("cancel", 0, "Cancel the scheduled import with this migration ID, unless it has already finished")
+	var listMigrationsFlag = flag.Bool("list-migrations", false, "List past migrations, optionally filtered with -state")
+	var state = flag.String("state", "", "Only applies with -list-migrations.  Only list migrations in this workflow state (e.g. 'failed')")
+	var check = flag.Bool("check", false, "Check that the domain can be reached and the API key is valid, without doing anything else")
+	var listAccountsFlag = flag.Bool("list-accounts", false, "List the IDs and names of the accounts you can access")
+	flag.Var(&guidsFlag, "guid", "GUID to schedule for import.  Several can be imported at once by separating them with commas, or by using this multiple times")
+	var guidFile = flag.String("guid-file", "", "A file of GUIDs or titles to schedule for import, one per line.  Blank lines and lines starting with # are ignored")
+	var guidUrl = flag.String("guid-from-url", "", "A Canvas URL with the GUID to schedule for import in it, e.g. copied from your browser")
+	var allowDuplicates = flag.Bool("allow-duplicates", false, "Import a GUID each time it's listed, instead of skipping the repeats in a batch")
+	var resume = flag.Bool("resume", false, "Resume an interrupted import of several GUIDs, skipping the ones that were already imported")
+	var name = flag.String("name", "", "A friendly name to record an import under in the index file.  Without -guid, check the status of the import recorded under this name")
+	var watch = flag.Bool("watch", false, "After scheduling an import, or with -status, wait for the migration to finish and exit non-zero if it failed")
+	var interval = flag.Duration("interval", WatchInterval, "Only applies with -watch.  How often to check the migration's state")
+	var retryIssueType = flag.String("retry-migration-on-issue-type", "", "Only applies with -watch.  If the migration fails with only issues of this type, import the GUID again")
+	var migrationRetries = flag.Int("migration-retries", 1, "The maximum number of times -retry-migration-on-issue-type will import the GUID again")
+	var verify = flag.Bool("verify", false, "Only applies with -watch.  Once the migration completes, check that the imported outcomes can be read back")
+	flag.StringVar(&metricsFile, "metrics-file", "", "Write Prometheus textfile metrics about the run to this file, e.g. for node_exporter's textfile collector")
+	var watchTimeout = flag.Duration("watch-timeout", WatchTimeout, "Only applies with -watch.  How long to wait for the migration to finish before giving up, or 0 to wait forever")
+	var watchLogFile = flag.String("watch-log", "", "Only applies with -watch.  Append each change of the migration's state, with timing, to this file")
+	var strict = flag.Bool("strict", false, "Only applies with -watch.  Fail if the migration finishes with any migration issues, not just if it fails")
 	var calcMethod = flag.String("calculation_method", "", "Valid calculation method (e.g. 'decaying_average', 'n_mastery', 'latest', 'highest')")
 	var calcInt = flag.Int("calculation_int", 0, "Only applies if the calculation_method is 'decaying_average' or 'n_mastery'")
 	var masteryPoints = flag.Int("mastery_points", 0, "The mastery threshold for the embedded rubric criterion")
@@ -163,62 +1099,716 @@ func main() {
 	flag.Var(&ratingsFlag, "ratings", "Ratings in the form of \"points,description\". This can be used multiple times"+
 		" (e.g. -ratings \"5,Exceeds Expectations\" -ratings \"3,Meets Expectations\" -ratings \"0,Does Not Meet Expectations\")."+
 		" The order of the ratings is preserved.")
+	flag.Var(&cookiesFlag, "cookie", "Cookie in the form \"name=value\" to send with each request, for authenticating with a"+
+		" session instead of an API key.  This can be used multiple times")
+	var keychain = flag.Bool("keychain", false, "Keep the API key for the domain in the OS keychain instead of the config file."+
+		"  The first time, you're prompted for the key and it's stored")
+	var insecure = flag.Bool("insecure", false, "Don't verify the server's TLS certificate, e.g. a self-signed one, and allow sending credentials over plain http to hosts other than localhost")
+	var proxy = flag.String("proxy", "", "The URL of the proxy to send requests through, instead of the one in $HTTPS_PROXY or $HTTP_PROXY (minus $NO_PROXY)")
+	var cacert = flag.String("cacert", "", "A PEM file of CA certificates to trust as well as the system's, e.g. for a self-hosted Canvas with its own CA")
+	var timeout = flag.Duration("timeout", 30*time.Second, "How long to wait for a response to each request")
+	var readTimeout = flag.Duration("read-timeout", 0, "How long to wait for a response to each GET request, e.g. listing GUIDs or checking status.  Defaults to -timeout")
+	var writeTimeout = flag.Duration("write-timeout", 0, "How long to wait for a response to each POST request, e.g. starting an import.  Defaults to -timeout")
+	var concurrency = flag.Int("concurrency", 4, "The most GUIDs to import at once in a batch, or requests to make at once when checking the status of several migrations")
+	var retries = flag.Int("retries", 3, "The number of times to retry a GET that fails to connect or gets a retryable status, or any request that's rate limited")
+	var retryOnStatus = flag.String("retry-on-status", "", "Comma separated HTTP status codes to retry (e.g. \"502,503,520\").  The default is any 5xx status")
+	var quiet = flag.Bool("quiet", false, "Only print errors and the final result")
+	var noProgress = flag.Bool("no-progress", false, "Don't print progress messages, only warnings, errors and the final result")
+	var verboseFlag = flag.Bool("verbose", false, "Also print the method, URL and body of each request and the status of each response")
+	var userAgentFlag = flag.String("user-agent", "", "The User-Agent to send, e.g. to tag requests with your institution.  Defaults to user_agent in the config file, or outcomes-import-tool/<version>")
+	var basePath = flag.String("base-path", "", "The path Canvas is mounted under, e.g. /canvas, which is prepended to every endpoint.  Defaults to base_path in the config file")
+	var showStatus = flag.Bool("show-status", false, "Print the HTTP status line (e.g. \"200 OK\") of each response")
+	flag.Var(&headersFlag, "header", "Extra header in the form \"Name: value\" to send with each request.  This can be used multiple times")
+	flag.Var(&secretHeadersFlag, "secret-header", "Like -header, but the value is masked in -debug output.  This can be used multiple times")
+	flag.Var(&redactFlag, "redact-header", "Name of a header to mask in -debug output, in addition to Authorization, Cookie and"+
+		" any -secret-header.  This can be used multiple times")
+	var dumpFixtureDir = flag.String("dump-fixture", "", "Write each request and the response it received as a JSON fixture file in this directory,"+
+		" e.g. for reproducing a bug.  Secret headers are masked")
+	var yes = flag.Bool("yes", false, "Import without asking for confirmation first.  The confirmation is also skipped when stdin or stdout isn't a terminal")
+	flag.BoolVar(yes, "y", false, "Short for -yes")
+	var all = flag.Bool("all", false, "Import every available framework, e.g. into a new sandbox.  Asks for confirmation first, unless -yes is given")
+	var reimport = flag.Bool("reimport", false, "Import the last GUID imported again, e.g. into a rebuilt sandbox.  The same as -guid last")
+	var interactive = flag.Bool("interactive", false, "Pick the framework to import from a numbered menu of the available GUIDs")
+	var dryRun = flag.Bool("dry-run", false, "Resolve the GUIDs to import and print the requests that would schedule them, without sending those requests")
+	var printEndpoint = flag.Bool("print-endpoint", false, "Print the URL that would be requested and exit without requesting it")
+	var debug = flag.Bool("debug", false, "Print the headers of each request and response, with secret headers masked")
 	var help = flag.Bool("help", false, "Print the help menu and exit")
-	var version = flag.Bool("version", false, "Print the version and exit")
-	flag.Parse()
-
-	if *version {
-		fmt.Println("[+] Outcomes Import Tool Version: ", Version)
+	var printVersion = flag.Bool("version", false, "Print the version and exit")
+	flag.StringVar(&profile, "profile", DefaultProfile, "The named profile in the config file to read and save settings in, e.g. for separate sandbox and production domains")
+	flag.StringVar(&configPath, "config", "", "Path of the config file, instead of outcomes-import-tool/config.json in $XDG_CONFIG_HOME or ~/.config")
+	flag.CommandLine.Parse(bareStatusArgs(os.Args[1:]))
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		time.Sleep(AbortGrace)
+		aborted()
+	}()
+	if *printVersion {
+		fmt.Println("[+] Outcomes Import Tool Version:", version)
 		os.Exit(0)
 	}
+	// the GUIDs and titles to import
+	entries := splitLists(guidsFlag)
 
 	if *help {
 		printHelp()
 		os.Exit(0)
 	}
 
-	if cf := configFromFile(); cf != nil {
-		if *apikey == "" {
-			fmt.Println("[+] Using API key from config file")
-			apikey = &cf.Apikey
-		}
-		if *status == 0 {
-			fmt.Println("[+] Using migration ID from config file")
-			status = &cf.MigrationId
+	// the count is meant to be scraped, so it's printed on its own
+	if *quiet || *countOnly {
+		logLevel = LogQuiet
+	} else if *noProgress {
+		logLevel = LogNoProgress
+	} else if *verboseFlag {
+		logLevel = LogVerbose
+	}
+	if *jsonFlag {
+		*format = "json"
+	} else if *csvFlag {
+		*format = "csv"
+	}
+	jsonOutput = *format == "json"
+	csvOutput = *format == "csv"
+	if *outputPath != "" {
+		f, err := os.OpenFile(*outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			fatalExit("Unable to open output file:", err)
+		}
+		defer f.Close()
+		output = f
+	}
+	if *wrapOutput {
+		wrapWidth = terminalWidth()
+	}
+	colorOutput = !*noColor && os.Getenv("NO_COLOR") == "" && output == os.Stdout && term.IsTerminal(int(os.Stdout.Fd()))
+	if *delimiterFlag != "" {
+		// a tab is hard to type as an argument, so it can be escaped
+		delimiter = strings.Replace(*delimiterFlag, `\t`, "\t", -1)
+	}
+
+	statusIds, err := parseMigrationIds(*statusFlag)
+	if err != nil {
+		errAndExit(err)
+	}
+	var status = new(int)
+	if len(statusIds) > 0 {
+		*status = statusIds[0]
+	}
+	if *concurrency < 1 {
+		errAndExit("-concurrency must be at least 1")
+	}
+	if *interval <= 0 {
+		errAndExit("-interval must be positive")
+	}
+
+	if *validateGuid != "" {
+		if !validGuid(*validateGuid) {
+			fatalExit(fmt.Sprintf("\"%s\" is not a valid GUID", *validateGuid))
+		}
+		fmt.Printf("[+] \"%s\" is a valid GUID\n", *validateGuid)
+		os.Exit(0)
+	}
+
+	// the history is local, so these don't need a domain or API key
+	if *history {
+		var entries []historyEntry
+		for _, h := range currentConfig().History {
+			if h.MigrationId > *sinceMigration {
+				entries = append(entries, h)
+			}
+		}
+		printHistory(entries)
+		os.Exit(0)
+	}
+	if *prune {
+		if *keep <= 0 && *olderThan <= 0 {
+			errAndExit("-prune-history requires -keep or -older-than")
+		}
+		pruneHistory(*keep, *olderThan)
+		os.Exit(0)
+	}
+
+	if *guidUrl != "" {
+		if len(entries) > 0 {
+			errAndExit("-guid and -guid-from-url can't be used together")
+		}
+		extracted, err := guidFromUrl(*guidUrl)
+		if err != nil {
+			errAndExit(err)
+		}
+		progress("[+] Using GUID %s from the URL\n", extracted)
+		entries = []string{extracted}
+	}
+	if *guidFile != "" {
+		lines, err := readGuidFile(*guidFile)
+		if err != nil {
+			fatalExit("Unable to read the GUID file:", err)
+		}
+		entries = append(entries, lines...)
+	}
+
+	// "last" is the GUID imported most recently, for re-importing it
+	if *reimport {
+		entries = []string{LastGuidEntry}
+	}
+	for i, entry := range entries {
+		if strings.EqualFold(entry, LastGuidEntry) {
+			if entries[i] = currentConfig().LastGuid; entries[i] == "" {
+				fatalExit("No GUID has been imported yet, so there's no last GUID to import again")
+			}
+			progress("[+] Using the last GUID imported, %s\n", entries[i])
+		}
+	}
+
+	// where each setting was resolved from, logged with -debug
+	sources := map[string]string{"apikey": "flag", "domain": "flag", "migration_id": "flag", "account": "flag"}
+
+	if *name != "" && len(entries) == 0 {
+		entry, ok := indexFromFile()[*name]
+		if !ok {
+			fatalExit(fmt.Sprintf("No import named \"%s\" in %s", *name, indexFile()))
+		}
+		progress("[+] Using migration ID %d recorded as \"%s\"\n", entry.MigrationId, *name)
+		status = &entry.MigrationId
+		sources["migration_id"] = "index file"
+		if *domain == "" {
+			domain = &entry.Domain
+			sources["domain"] = "index file"
+		}
+	}
+
+	if *apikeyStdin {
+		if *apikey != "" {
+			errAndExit("-apikey and -apikey-stdin can't be used together")
+		}
+		key := readApikeyStdin()
+		apikey = &key
+		apikeyUnsaved = true
+		sources["apikey"] = "stdin"
+	}
+	// flags come first, then the environment, then the config file
+	readApikeyFile := func(path string, source string) {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			fatalExit("Unable to read the API key file:", err)
+		}
+		key := strings.TrimRight(string(contents), " \t\r\n")
+		apikey = &key
+		sources["apikey"] = source
+	}
+	if *apikey == "" && *apikeyFile != "" {
+		readApikeyFile(*apikeyFile, "-apikey-file")
+	}
+	if key := os.Getenv("OUTCOMES_IMPORT_APIKEY"); *apikey == "" && key != "" {
+		apikey = &key
+		sources["apikey"] = "OUTCOMES_IMPORT_APIKEY"
+	}
+	if path := os.Getenv("CANVAS_API_KEY_FILE"); *apikey == "" && path != "" {
+		readApikeyFile(path, "CANVAS_API_KEY_FILE")
+	}
+
+	if cf := configFromFile(); cf != nil {
+		if *apikey == "" && !*keychain {
+			progress("[+] Using API key from config file\n")
+			apikey = &cf.Apikey
+			sources["apikey"] = "config file"
+		}
+		if *status == 0 {
+			progress("[+] Using migration ID from config file\n")
+			status = &cf.MigrationId
+			sources["migration_id"] = "config file"
 		}
 		if *domain == "" {
-			fmt.Println("[+] Using domain from config file")
+			progress("[+] Using domain from config file\n")
 			domain = &cf.Domain
+			sources["domain"] = "config file"
+		}
+		if *userAgentFlag == "" {
+			userAgentFlag = &cf.UserAgent
+		}
+		if *basePath == "" {
+			basePath = &cf.BasePath
+		}
+		if usesDefaultAccount(cf, *account, *course, *global) {
+			progress("[+] Using default account from config file\n")
+			account = &cf.DefaultAccount
+			sources["account"] = "config file"
+		}
+	}
+	if *global {
+		*account = ""
+		sources["account"] = "-global"
+	}
+	// the account is remembered like the domain, so that later commands (such
+	// as checking the status of an import) stay scoped to it until -global
+	if sources["account"] == "flag" && *account != "" && !strings.Contains(*account, ",") {
+		cf := currentConfig()
+		cf.DefaultAccount = *account
+		cf.DefaultScope = ""
+		cf.writeToFile()
+	} else if *global {
+		cf := currentConfig()
+		cf.DefaultAccount = ""
+		cf.writeToFile()
+	}
+
+	// -timeout is saved so that it doesn't have to be given every time
+	timeoutGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		timeoutGiven = timeoutGiven || f.Name == "timeout"
+	})
+	if timeoutGiven {
+		cf := currentConfig()
+		cf.Timeout = timeout.String()
+		cf.writeToFile()
+	} else if cf := configFromFile(); cf != nil && cf.Timeout != "" {
+		saved, err := time.ParseDuration(cf.Timeout)
+		if err != nil {
+			fatalExit(fmt.Sprintf("\"%s\" in the config file is not a valid timeout", cf.Timeout))
+		}
+		timeout = &saved
+	}
+
+	if *format != "text" && *format != "markdown" && *format != "json" && *format != "csv" {
+		errAndExit(fmt.Sprintf("\"%s\" is not a valid format", *format))
+	}
+
+	var retryStatuses []int
+	if *retryOnStatus != "" {
+		var err error
+		if retryStatuses, err = parseStatusCodes(*retryOnStatus); err != nil {
+			errAndExit(err)
+		}
+	}
+
+	headers := http.Header(headersFlag)
+	redact := append(append([]string{}, DefaultRedactedHeaders...), redactFlag...)
+	for name, values := range secretHeadersFlag {
+		if headers == nil {
+			headers = http.Header{}
 		}
+		headers[name] = append(headers[name], values...)
+		redact = append(redact, name)
 	}
 
-	req := request{Apikey: *apikey, Domain: *domain}
+	// nothing is imported with -dry-run or -print-endpoint, so there's nothing
+	// to confirm
+	confirm := !*yes && !*dryRun && !*printEndpoint && isInteractive() && term.IsTerminal(int(os.Stdout.Fd()))
+	req := request{
+		Apikey:        *apikey,
+		Domain:        *domain,
+		Cookies:       cookiesFlag,
+		ShowStatus:    *showStatus,
+		Retries:       *retries,
+		RetryStatuses: retryStatuses,
+		Headers:       headers,
+		UserAgent:     *userAgentFlag,
+		BasePath:      normalizeBasePath(*basePath),
+		CacheTTL:      *cacheTTL,
+		Debug:         *debug,
+		Redact:        redact,
+		FixtureDir:    *dumpFixtureDir,
+		Timeout:       *timeout,
+		ReadTimeout:   *readTimeout,
+		WriteTimeout:  *writeTimeout,
+		PrintEndpoint: *printEndpoint,
+		Confirm:       confirm,
+		DryRun:        *dryRun,
+	}
+	if *keychain && req.Apikey == "" && req.Domain != "" {
+		req.Apikey = keychainApikey(normalizeDomain(req.Domain))
+		sources["apikey"] = "keychain"
+	}
+	// -check reports a rejected key instead, and a batch run with -yes is
+	// left to fail rather than wait for someone to type a new one
+	unattended := (len(entries) > 1 || *all) && *yes
+	if isInteractive() && !*check && !unattended {
+		req.Reprompt = &apikeyReprompt{prompt: promptApikey}
+	}
+	targets := importTargets(*account, *course)
+	if len(targets) == 1 {
+		req.Account = targets[0].Account
+		req.Course = targets[0].Course
+	} else if len(targets) > 1 && (len(entries) != 1 || *watch || *name != "") {
+		errAndExit("Several -account or -course targets can only be used to import a single -guid, without -watch or -name")
+	}
+	if req.Debug {
+		printSources(os.Stderr, req, *status, sources)
+	}
+	if *refresh {
+		req.CacheTTL = 0
+	}
 	verifyRequest(&req)
 	req.Domain = normalizeDomain(req.Domain)
+	checkPlaintext(req.Domain, *insecure)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil || u.Host == "" {
+			errAndExit(fmt.Sprintf("\"%s\" is not a valid proxy URL", *proxy))
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	if *insecure && *cacert != "" {
+		errAndExit("-insecure and -cacert can't be used together.  Use -cacert to trust the server's certificate, or -insecure to not check it at all")
+	} else if *insecure || *cacert != "" {
+		tlsConfig, err := newTLSConfig(*insecure, *cacert)
+		if err != nil {
+			fatalExit(err)
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	req.Transport = transport
+	if req.FixtureDir != "" {
+		if err := os.MkdirAll(req.FixtureDir, 0755); err != nil {
+			fatalExit("Unable to create fixture directory:", err)
+		}
+	}
+
+	var watchLog io.Writer
+	if *watch && *watchLogFile != "" {
+		f, err := os.OpenFile(*watchLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fatalExit("Unable to open watch log:", err)
+		}
+		defer f.Close()
+		watchLog = f
+	}
+
+	if *all {
+		lookup := req.lookup()
+		guids, err := getAvailable(&lookup)
+		if err != nil {
+			requestFailed(err)
+		}
+		if len(guids) == 0 {
+			fatalExit(NoGuidsAvailable)
+		}
+		question := fmt.Sprintf("[+] Import all %d available frameworks into %s on %s?", len(guids), contextName(req), req.Domain)
+		if !*yes && !req.DryRun && !req.PrintEndpoint {
+			if !isInteractive() {
+				fatalExit("-all imports every available framework, so it needs -yes when it can't ask for confirmation")
+			} else if !promptYesNo(question) {
+				progress("[+] Quit without importing anything\n")
+				exit(0)
+			}
+		}
+		// they were all confirmed at once
+		req.Confirm = false
+		entries = nil
+		for _, g := range guids {
+			entries = append(entries, g.Guid)
+		}
+	}
+
+	if *interactive {
+		lookup := req.lookup()
+		guids, err := getAvailable(&lookup)
+		if err != nil {
+			requestFailed(err)
+		}
+		if len(guids) == 0 {
+			fatalExit(NoGuidsAvailable)
+		}
+		guid, ok := pickGuid(guids, stdin)
+		if !ok {
+			progress("[+] Quit without importing anything\n")
+			exit(0)
+		}
+		entries = []string{guid}
+	}
 
-	if *available {
-		printAvailable(req)
-	} else if *guid != "" {
-		importGuid(req, *guid, *calcMethod, *calcInt, *masteryPoints, *pointsPossible, ratingsFlag)
+	if *available && *countOnly {
+		guids, err := getAvailable(&req)
+		if err != nil {
+			requestFailed(err)
+		}
+		fmt.Fprintln(output, len(filterGuids(guids, *filter)))
+	} else if *available {
+		printAvailable(req, *format, *filter, *unique)
+	} else if *refreshTitlesFlag {
+		refreshTitles(req)
+	} else if *check {
+		checkConnection(req)
+	} else if *listAccountsFlag {
+		listAccounts(req)
+	} else if *cancel != 0 {
+		cancelMigration(req, *cancel)
+	} else if *listMigrationsFlag {
+		listMigrations(req, *state, *sinceMigration)
+	} else if *compare != "" {
+		compareMigrations(req, *compare)
+	} else if len(targets) > 1 {
+		importIntoTargets(req, entries[0], targets, *calcMethod, *calcInt, *masteryPoints, *pointsPossible, ratingsFlag)
+	} else if len(entries) > 0 {
+		if *name != "" && len(entries) > 1 {
+			errAndExit("-name can only be used when importing a single GUID")
+		}
+		isBatch := len(entries) > 1
+		req.InBatch = isBatch
+		if isBatch && !*allowDuplicates {
+			entries = dedupeEntries(req, entries)
+		}
+		batch := &batchState{Domain: req.Domain, Completed: map[string]int{}}
+		if *resume {
+			if saved := batchStateFromFile(); saved != nil && saved.Domain == req.Domain {
+				batch = saved
+			} else {
+				warn("[-] No interrupted batch import for %s to resume, starting from the beginning\n", req.Domain)
+			}
+		}
+
+		start := time.Now()
+		summary := &batchSummary{Results: []batchResult{}}
+		pending := batch.pending(entries)
+		summary.Skipped = len(entries) - len(pending)
+		if req.Confirm && len(pending) > 1 && !req.DryRun {
+			// confirmed together before the imports start, so the workers
+			// don't ask at the same time
+			logAt(LogQuiet, "[+] About to import into %s on %s:\n", contextName(req), req.Domain)
+			for _, entry := range pending {
+				logAt(LogQuiet, "    %s\n", entry)
+			}
+			if !promptYesNo(fmt.Sprintf("[+] Import these %d frameworks?", len(pending))) {
+				progress("[+] Quit without importing anything\n")
+				exit(0)
+			}
+			req.Confirm = false
+		}
+		// batchLock guards the batch state, and keeps the status
+		// of each migration from being printed in the middle of another's
+		var batchLock sync.Mutex
+		completed := func(entry string, migrationId int) {
+			if !isBatch {
+				return
+			}
+			batchLock.Lock()
+			defer batchLock.Unlock()
+			batch.Completed[entry] = migrationId
+			batch.writeToFile()
+		}
+		printStatus := func(mstatus migrationStatus) {
+			if jsonOutput && isBatch {
+				return
+			}
+			batchLock.Lock()
+			defer batchLock.Unlock()
+			printMigrationStatus(mstatus)
+		}
+		var paceLock sync.Mutex
+		var lastStarted time.Time
+		// importEntry imports and, with -watch, watches a single entry.  A
+		// failure is returned as a result with an Error, along with the code a
+		// single import exits with.
+		importEntry := func(entry string) (batchResult, int) {
+			if *all && !req.DryRun {
+				// spread the imports of the whole catalog out for the rate limit
+				paceLock.Lock()
+				if !lastStarted.IsZero() {
+					sleep(time.Until(lastStarted.Add(AllImportPause)))
+				}
+				lastStarted = time.Now()
+				paceLock.Unlock()
+			}
+			countImport()
+			nimport, err := importGuid(req, entry, *calcMethod, *calcInt, *masteryPoints, *pointsPossible, ratingsFlag)
+			if err != nil {
+				return batchResult{Entry: entry, Error: err.Error(), StatusCode: statusCode(err)}, ExitRequestError
+			}
+			if req.DryRun {
+				return batchResult{Entry: entry, Guid: nimport.Guid}, 0
+			}
+			migrationId := nimport.MigrationId
+			completed(entry, migrationId)
+			if *name != "" {
+				recordInIndex(*name, indexEntry{MigrationId: migrationId, Domain: req.Domain, Guid: nimport.Guid})
+				progress("[+] Recorded migration %d as \"%s\" in %s\n", migrationId, *name, indexFile())
+			}
+			if !*watch {
+				return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId}, 0
+			}
+			mstatus, err := watchMigration(req, nimport, *interval, *watchTimeout, watchLog)
+			if err == nil {
+				updateHistoryState(mstatus)
+			}
+			for retry := 1; retry <= *migrationRetries && failedOnlyWithIssueType(mstatus, *retryIssueType); retry++ {
+				printStatus(mstatus)
+				progress("\n[+] Migration %d failed with only '%s' issues, importing again (retry %d of %d)\n",
+					migrationId, *retryIssueType, retry, *migrationRetries)
+				var retried newImport
+				if retried, err = importGuid(req, entry, *calcMethod, *calcInt, *masteryPoints, *pointsPossible, ratingsFlag); err != nil {
+					break
+				}
+				nimport = retried
+				migrationId = nimport.MigrationId
+				completed(entry, migrationId)
+				if mstatus, err = watchMigration(req, nimport, *interval, *watchTimeout, watchLog); err != nil {
+					break
+				}
+				updateHistoryState(mstatus)
+			}
+			if errors.Is(err, errWatchTimedOut) {
+				printStatus(mstatus)
+				return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId, Error: err.Error()}, ExitWatchTimedOut
+			} else if err != nil {
+				return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId, Error: err.Error(), StatusCode: statusCode(err)}, ExitRequestError
+			}
+			printStatus(mstatus)
+			if reason := finalStatusError(mstatus, *strict); reason != "" {
+				return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId, Error: reason}, ExitFailure
+			}
+			if *verify {
+				if found, err := verifyImport(req, nimport); err != nil {
+					return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId, Error: err.Error(), StatusCode: statusCode(err)}, ExitRequestError
+				} else if !found {
+					return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId, Error: fmt.Sprintf("The migration completed, but no outcome group for %s could be found", nimport.Guid)}, ExitFailure
+				}
+			}
+			return batchResult{Entry: entry, Guid: nimport.Guid, MigrationId: migrationId}, 0
+		}
+		results, codes := importConcurrently(pending, *concurrency, isBatch, importEntry)
+		for i, result := range results {
+			if result.Error != "" && !isBatch {
+				// but a failure ends a single import
+				printError(result.Error, result.StatusCode)
+				exit(codes[i])
+			}
+			summary.add(result)
+		}
+		if isBatch {
+			if summary.Failed == 0 {
+				// kept after a failure, so -resume can retry only the failed ones
+				os.Remove(batchStateFile())
+			}
+			summary.ElapsedSeconds = time.Since(start).Seconds()
+			printBatchSummary(summary, *format)
+			if summary.Failed > 0 {
+				exit(ExitFailure)
+			}
+		}
+	} else if len(statusIds) > 1 && *watch {
+		statuses, err := watchMigrations(req, statusIds, *concurrency, *interval, *watchTimeout, watchLog)
+		if errors.Is(err, errWatchTimedOut) {
+			for _, mstatus := range statuses {
+				printMigrationStatus(mstatus)
+			}
+			fmt.Fprintln(os.Stderr, "\n[-]", err)
+			exit(ExitWatchTimedOut)
+		} else if err != nil {
+			requestFailed(err)
+		}
+		for _, mstatus := range statuses {
+			updateHistoryState(mstatus)
+			printMigrationStatus(mstatus)
+			if reason := finalStatusError(mstatus, false); reason != "" {
+				fmt.Fprintln(os.Stderr, "\n[-]", reason)
+			}
+		}
+		saveErrorReports(req, *saveReport, statuses...)
+		exit(statusExitCode(statuses...))
+	} else if len(statusIds) > 1 {
+		statuses := getStatuses(req, statusIds, *concurrency)
+		saveErrorReports(req, *saveReport, statuses...)
+		exit(statusExitCode(statuses...))
+	} else if *status != 0 && *watch {
+		mstatus, err := watchMigration(req, newImport{MigrationId: *status}, *interval, *watchTimeout, watchLog)
+		if errors.Is(err, errWatchTimedOut) {
+			printMigrationStatus(mstatus)
+			fmt.Fprintln(os.Stderr, "\n[-]", err)
+			exit(ExitWatchTimedOut)
+		} else if err != nil {
+			requestFailed(err)
+		}
+		updateHistoryState(mstatus)
+		printMigrationStatus(mstatus)
+		if reason := finalStatusError(mstatus, false); reason != "" {
+			fmt.Fprintln(os.Stderr, "\n[-]", reason)
+		}
+		saveErrorReports(req, *saveReport, mstatus)
+		exit(statusExitCode(mstatus))
 	} else if *status != 0 {
-		getStatus(req, *status)
+		mstatus, err := getStatus(req, *status)
+		if err != nil {
+			requestFailed(err)
+		}
+		saveErrorReports(req, *saveReport, mstatus)
+		exit(statusExitCode(mstatus))
 	} else {
 		fatalExit("No recent migration ID, and none specified to query status on")
 	}
+	exit(0)
 }
 
+// normalizeDomain turns what the user gave as the domain into a base URL.  A
+// scheme, port or path they gave is kept, https is assumed otherwise, and a
+// bare name without any dots, like "utah", is taken as an Instructure subdomain.
 func normalizeDomain(domain string) string {
-	retval := domain
+	domain = strings.TrimSpace(domain)
 	if domain == "localhost" {
 		return "http://localhost:3000"
-		// if we start with http then don't add it, otherwise do
-	} else if !strings.HasPrefix(retval, "http") {
-		retval = fmt.Sprintf("https://%s", retval)
-		if !strings.HasSuffix(retval, "com") && !strings.HasSuffix(retval, "/") {
-			retval = fmt.Sprintf("%s.instructure.com", retval)
+	}
+	hasScheme := strings.Contains(domain, "://")
+	if !hasScheme {
+		domain = "https://" + domain
+	}
+	u, err := url.Parse(domain)
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(domain, "/")
+	}
+	host, port := u.Hostname(), u.Port()
+	if host == "localhost" && !hasScheme {
+		u.Scheme = "http"
+	} else if !strings.Contains(host, ".") && !strings.Contains(host, ":") && host != "localhost" {
+		u.Host = host + ".instructure.com"
+		if port != "" {
+			u.Host += ":" + port
 		}
 	}
-	return strings.TrimSuffix(retval, "/")
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+// checkPlaintext refuses to send credentials over plain http to anything other
+// than a local development server, unless insecure is set.
+func checkPlaintext(domain string, insecure bool) {
+	u, err := url.Parse(domain)
+	if err != nil || u.Scheme != "http" {
+		return
+	}
+	host := u.Hostname()
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		return
+	}
+	if !insecure {
+		fatalExit(fmt.Sprintf("Refusing to send credentials over unencrypted http to %s.  Use https, or pass -insecure if you really mean it", host))
+	}
+	fmt.Fprintf(os.Stderr, "[-] WARNING: sending credentials over unencrypted http to %s\n", host)
+}
+
+// newTLSConfig returns the TLS settings for a self-hosted Canvas: trusting
+// the CAs in the cacert file as well as the system's, or with insecure, not
+// verifying the certificate at all
+func newTLSConfig(insecure bool, cacert string) (*tls.Config, error) {
+	if insecure {
+		fmt.Fprintln(os.Stderr, "[-] WARNING: -insecure turns off TLS certificate verification.  Anyone on the network could read or change the requests, including the API key")
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	pem, err := ioutil.ReadFile(cacert)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the CA certificates: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No PEM certificates found in %s", cacert)
+	}
+	return &tls.Config{RootCAs: pool}, nil
 }
 
 func errAndExit(message ...interface{}) {
@@ -227,7 +1817,7 @@ func errAndExit(message ...interface{}) {
 }
 
 func verifyRequest(req *request) {
-	if req.Apikey == "" {
+	if req.Apikey == "" && len(req.Cookies) == 0 {
 		errAndExit(fmt.Sprintf("Whoops, no API key stored in config file \"%s\" and none passed as an arg", configFile()))
 	}
 	if req.Domain == "" {
@@ -235,9 +1825,70 @@ func verifyRequest(req *request) {
 	}
 }
 
-func httpRequest(req request) (*http.Client, *http.Request) {
-	client := &http.Client{}
-	hreq, err := http.NewRequest(
+// contextPath returns the API path of the context req is scoped to, which is
+// req.Course or req.Account when one is set and global otherwise.
+func contextPath(req request) string {
+	if req.Course != "" {
+		return fmt.Sprintf("%s/courses/%s", apiPath(req), url.PathEscape(req.Course))
+	} else if req.Account != "" {
+		return fmt.Sprintf("%s/accounts/%s", apiPath(req), url.PathEscape(req.Account))
+	}
+	return apiPath(req) + "/global"
+}
+
+// ApiVersionPath is the path of the Canvas API under the base path
+const ApiVersionPath = "/api/v1"
+
+// apiPath returns the path every endpoint starts with, including req.BasePath
+func apiPath(req request) string {
+	return req.BasePath + ApiVersionPath
+}
+
+// normalizeBasePath makes path start with a slash and not end with one, so
+// "canvas/" and "/canvas" both become "/canvas"
+func normalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// AllImportPause is the time between imports with -all
+const AllImportPause = 2 * time.Second
+
+// LastGuidEntry can be given to -guid to import the last GUID imported again
+const LastGuidEntry = "last"
+
+// contextName describes the context req is scoped to, for people
+func contextName(req request) string {
+	if req.Course != "" {
+		return "course " + req.Course
+	} else if req.Account != "" {
+		return "account " + req.Account
+	}
+	return "the global outcomes"
+}
+
+func outcomesImportPath(req request) string {
+	return contextPath(req) + "/outcomes_import"
+}
+
+// Doer sends HTTP requests.  *http.Client is one, and tests can set
+// request.Client to a fake to avoid the network.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// httpRequest builds the HTTP request for req, and the client to send it with,
+// which is req.Client when one is set
+func httpRequest(req request) (Doer, *http.Request) {
+	client := &http.Client{Timeout: req.timeout()}
+	if req.Transport != nil {
+		client.Transport = req.Transport
+	}
+	hreq, err := http.NewRequestWithContext(
+		ctx,
 		req.Method,
 		fmt.Sprintf("%s%s", req.Domain, req.Endpoint),
 		strings.NewReader(req.Body),
@@ -245,102 +1896,1266 @@ func httpRequest(req request) (*http.Client, *http.Request) {
 	if err != nil {
 		fatalExit(err)
 	}
-	hreq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", req.Apikey))
+	if len(req.Cookies) > 0 {
+		jar, _ := cookiejar.New(nil)
+		jar.SetCookies(hreq.URL, req.Cookies)
+		client.Jar = jar
+	}
+	if req.Apikey != "" {
+		hreq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", req.Apikey))
+	}
+	if req.UserAgent != "" {
+		hreq.Header.Set("User-Agent", req.UserAgent)
+	} else {
+		hreq.Header.Set("User-Agent", userAgent())
+	}
+	for name, values := range req.Headers {
+		hreq.Header[name] = values
+	}
+	hreq.Header.Set("Accept-Encoding", "gzip")
+	if req.Client != nil {
+		return req.Client, hreq
+	}
 	return client, hreq
 }
 
-func printAvailable(req request) {
-	guids := getAvailable(req)
-	printImportableGuids(guids)
-	migId := 0
-	if cff := configFromFile(); cff != nil {
-		migId = cff.MigrationId
+// viaProxy describes the proxy transport will send hreq through, if any
+func viaProxy(transport http.RoundTripper, hreq *http.Request) string {
+	t, ok := transport.(*http.Transport)
+	if !ok || t.Proxy == nil {
+		return ""
+	}
+	if proxy, err := t.Proxy(hreq); err == nil && proxy != nil {
+		return fmt.Sprintf(" (via proxy %s)", proxy.Redacted())
 	}
-	(&config{
-		Apikey:      req.Apikey,
-		Domain:      req.Domain,
-		MigrationId: migId,
-		Guids:       guids,
-	}).writeToFile()
+	return ""
 }
 
-func getAvailable(req request) []importableGuid {
-	req.Body = ""
-	req.Method = "GET"
-	req.Endpoint = "/api/v1/global/outcomes_import/available"
+// doRequest sends req and returns the response.  Connection errors and
+// responses with a retryable status are retried up to req.Retries times with
+// exponential backoff, or after the delay in the Retry-After header, as are
+// requests rejected by the Canvas rate limit.  If the server rejects the API
+// key and req.Reprompt is set, the user is prompted for a new key and the
+// request is retried once with it.  req.Apikey is updated to the new key.
+func doRequest(req *request) (*http.Response, error) {
+	if req.PrintEndpoint {
+		fmt.Printf("%s%s\n", req.Domain, req.Endpoint)
+		exit(0)
+	}
+	retries := 0
+	reprompted := false
+	for {
+		waitForRateLimit()
+		client, hreq := httpRequest(*req)
+		verbose("[+] %s %s%s\n", hreq.Method, hreq.URL, viaProxy(req.Transport, hreq))
+		if req.Body != "" {
+			verbose("%s\n", req.Body)
+		}
+		sent := time.Now()
+		resp, err := client.Do(hreq)
+		metricsLock.Lock()
+		metrics.Requests++
+		metrics.RequestSeconds += time.Since(sent).Seconds()
+		metricsLock.Unlock()
+		if ctx.Err() != nil {
+			aborted()
+		}
+		if err != nil && retries < req.Retries && req.idempotent() {
+			retries++
+			delay := retryDelay(nil, retries)
+			warn("[-] %s failed (%s), retrying in %s (retry %d of %d)\n", hreq.URL, err, delay, retries, req.Retries)
+			countRetry()
+			sleep(delay)
+			continue
+		}
+		if uerr, ok := err.(*url.Error); ok && uerr.Timeout() {
+			return nil, fmt.Errorf("request to %s timed out after %s", hreq.URL, req.timeout())
+		} else if err != nil {
+			return nil, err
+		}
+		if req.Debug {
+			// the request's headers are dumped after it's sent so that cookies
+			// added from the jar are included
+			fmt.Fprintf(os.Stderr, "> %s %s\n", hreq.Method, hreq.URL)
+			printHeaders(os.Stderr, "> ", hreq.Header, req.Redact)
+			fmt.Fprintf(os.Stderr, "< %s %s\n", resp.Proto, resp.Status)
+			printHeaders(os.Stderr, "< ", resp.Header, req.Redact)
+		}
+		if req.ShowStatus {
+			logAt(LogQuiet, "[+] %s %s: %s\n", hreq.Method, hreq.URL, resp.Status)
+		} else {
+			verbose("[+] %s %s: %s\n", hreq.Method, hreq.URL, resp.Status)
+		}
+		if err := decompress(resp, hreq.URL.String()); err != nil {
+			return nil, err
+		}
+		noteRateLimit(resp)
+		throttled := rateLimited(resp)
+		if retries < req.Retries && (throttled || req.idempotent() && req.retryable(resp.StatusCode)) {
+			resp.Body.Close()
+			retries++
+			delay := retryDelay(resp, retries)
+			if throttled {
+				warn("[-] %s was rate limited, retrying in %s (retry %d of %d)\n", hreq.URL, delay, retries, req.Retries)
+			} else {
+				warn("[-] %s returned %s, retrying in %s (retry %d of %d)\n", hreq.URL, resp.Status, delay, retries, req.Retries)
+			}
+			countRetry()
+			sleep(delay)
+			continue
+		}
+		if loginWall(resp) {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Authentication required — your token may be missing or invalid (%s was redirected to the login page %s)",
+				hreq.URL, resp.Request.URL)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && req.Reprompt != nil && !reprompted {
+			if apikey := req.Reprompt.apikey(); apikey != "" {
+				resp.Body.Close()
+				req.Apikey = apikey
+				reprompted = true
+				continue
+			}
+		}
+		if req.FixtureDir != "" {
+			dumpFixture(*req, hreq, resp)
+		}
+		return resp, nil
+	}
+}
 
-	client, hreq := httpRequest(req)
-	fmt.Printf("[+] Requesting available guids from %s", hreq.URL)
-	resp, err := client.Do(hreq)
-	if err != nil {
-		fatalExit(err)
+// apikeyReprompt asks for a new API key the first time the key is rejected,
+// and gives that same key to every copy of the request, such as a batch's
+// workers, whose key is rejected after
+type apikeyReprompt struct {
+	prompt func() string
+	once   sync.Once
+	newKey string
+}
+
+func (r *apikeyReprompt) apikey() string {
+	r.once.Do(func() {
+		warn("[-] The server rejected the API key (401 Unauthorized)\n")
+		r.newKey = r.prompt()
+	})
+	return r.newKey
+}
+
+// readResponse returns the body of resp, or an error if its status isn't 2xx
+// or the body is a Canvas error
+func readResponse(resp *http.Response) ([]byte, error) {
+	if err := checkResponse(resp); err != nil {
+		return nil, err
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := nonJsonError(resp, body); err != nil {
+		return nil, err
+	}
+	if messages := errorMessages(body); len(messages) > 0 {
+		return nil, &responseError{resp.StatusCode, fmt.Sprintf("Canvas returned an error: %s", strings.Join(messages, "; "))}
+	}
+	return body, nil
+}
 
-	var errs apiErrors
-	if json.NewDecoder(bytes.NewReader(body)).Decode(&errs); len(errs.Errors) > 0 {
-		printErrors(errs.Errors)
-		os.Exit(1)
+// MaxErrorBody is the most of a non-JSON error response that's printed
+const MaxErrorBody = 500
+
+// checkResponse returns an error if resp's status isn't 2xx, with Canvas's
+// error messages, or the start of the body when it doesn't have any
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := nonJsonError(resp, body); err != nil {
+		return err
 	}
+	detail := strings.Join(errorMessages(body), "; ")
+	if detail == "" {
+		detail = strings.TrimSpace(string(body))
+		if len(detail) > MaxErrorBody {
+			detail = detail[:MaxErrorBody] + "..."
+		}
+	}
+	if detail == "" {
+		return &responseError{resp.StatusCode, fmt.Sprintf("Canvas returned %s", resp.Status)}
+	}
+	return &responseError{resp.StatusCode, fmt.Sprintf("Canvas returned %s: %s", resp.Status, detail)}
+}
 
-	var guids []importableGuid
-	if e := json.NewDecoder(bytes.NewReader(body)).Decode(&guids); e != nil {
-		fatalExit("JSON decoding error.  Make sure your API key is correct and that you have permission to read global outcomes", e)
+// nonJsonError returns an error describing resp if its body isn't JSON, e.g.
+// an HTML error page from a proxy in front of Canvas, rather than leaving it
+// to fail decoding with a baffling "invalid character '<'"
+func nonJsonError(resp *http.Response, body []byte) error {
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" || strings.HasSuffix(mediaType, "json") || json.Valid(body) {
+		return nil
+	}
+	if len(trimmed) > MaxErrorBody {
+		trimmed = trimmed[:MaxErrorBody] + "..."
+	}
+	if contentType == "" {
+		contentType = "no Content-Type"
 	}
-	return guids
+	return &responseError{resp.StatusCode, fmt.Sprintf("The server returned a non-JSON response (%s, %s), so it may not be Canvas or may be misconfigured: %s",
+		resp.Status, contentType, trimmed)}
 }
 
-func getStatus(req request, migrationId int) {
-	req.Body = ""
-	req.Method = "GET"
-	req.Endpoint = fmt.Sprintf(
-		"/api/v1/global/outcomes_import/migration_status/%d",
-		migrationId,
-	)
+var loginPathPattern = regexp.MustCompile(`(?i)^/login(/|$)`)
+
+// loginWall reports whether resp was redirected to Canvas's login page, which
+// is what happens to API requests without a valid token on some instances
+func loginWall(resp *http.Response) bool {
+	return resp.Request != nil && resp.Request.Response != nil && loginPathPattern.MatchString(resp.Request.URL.Path)
+}
 
-	client, hreq := httpRequest(req)
+var fixtureCount = 0
 
-	fmt.Printf("[+] Retrieving status for migration %d\n", migrationId)
-	resp, err := client.Do(hreq)
+// dumpFixture writes the exchange of hreq and resp to a file in req.FixtureDir.
+// The response body is read in full and replaced so the caller can still read it.
+func dumpFixture(req request, hreq *http.Request, resp *http.Response) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
 		fatalExit(err)
 	}
-	defer resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
 
-	var mstatus migrationStatus
-	if e := json.NewDecoder(resp.Body).Decode(&mstatus); e != nil {
-		fatalExit("JSON decoding error.  Make sure your API key is correct and that you have permission to read global outcomes", e)
+	redacted := func(h http.Header) http.Header {
+		r := http.Header{}
+		for name, values := range h {
+			if isRedacted(name, req.Redact) {
+				values = []string{"[REDACTED]"}
+			}
+			r[name] = values
+		}
+		return r
+	}
+	var f fixture
+	f.Request.Method = req.Method
+	f.Request.Endpoint = req.Endpoint
+	f.Request.Headers = redacted(hreq.Header)
+	f.Request.Body = req.Body
+	f.Response.StatusCode = resp.StatusCode
+	f.Response.Headers = redacted(resp.Header)
+	f.Response.Body = string(body)
+
+	metricsLock.Lock()
+	fixtureCount++
+	count := fixtureCount
+	metricsLock.Unlock()
+	name := strings.Trim(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(hreq.URL.Path, "-"), "-")
+	path := filepath.Join(req.FixtureDir, fmt.Sprintf("%03d-%s-%s.json", count, strings.ToLower(req.Method), name))
+	b, _ := json.MarshalIndent(f, "", "  ")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		fatalExit("Unable to write fixture:", err)
+	}
+	progress("[+] Wrote fixture %s\n", path)
+}
+
+// printSources prints the value of each setting and where it came from.  Values
+// that are still empty came from nowhere, so they're marked as unset.
+func printSources(w io.Writer, req request, migrationId int, sources map[string]string) {
+	apikey := req.Apikey
+	if apikey != "" {
+		apikey = "[REDACTED]"
+	}
+	settings := []struct {
+		name  string
+		value string
+	}{
+		{"apikey", apikey},
+		{"domain", req.Domain},
+		{"migration_id", strconv.Itoa(migrationId)},
+		{"account", req.Account},
+	}
+	for _, setting := range settings {
+		source := sources[setting.name]
+		if setting.value == "" || setting.value == "0" {
+			source = "unset"
+		}
+		fmt.Fprintf(w, "[+] %s = %q (%s)\n", setting.name, setting.value, source)
+	}
+}
+
+// printHeaders prints h to w one per line in a stable order, masking the values
+// of any headers named in redact.
+func printHeaders(w io.Writer, prefix string, h http.Header, redact []string) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range h[name] {
+			if isRedacted(name, redact) {
+				value = "[REDACTED]"
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+func isRedacted(name string, redact []string) bool {
+	for _, r := range redact {
+		if http.CanonicalHeaderKey(r) == http.CanonicalHeaderKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (req request) timeout() time.Duration {
+	if req.Method == "GET" && req.ReadTimeout > 0 {
+		return req.ReadTimeout
+	} else if req.Method != "GET" && req.WriteTimeout > 0 {
+		return req.WriteTimeout
+	}
+	return req.Timeout
+}
+
+// Canvas throttles each API key with a leaky bucket, reporting what's left in
+// the X-Rate-Limit-Remaining header.  Once it drops below RateLimitThreshold,
+// requests are paused for RateLimitPause to let the bucket drain.
+const (
+	RateLimitThreshold = 50
+	RateLimitPause     = 5 * time.Second
+)
+
+var (
+	rateLimitLock    sync.Mutex
+	rateLimitedUntil time.Time
+)
+
+// noteRateLimit pauses the following requests if resp shows we're close to
+// the rate limit
+func noteRateLimit(resp *http.Response) {
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-Rate-Limit-Remaining"), 64)
+	if err != nil || remaining >= RateLimitThreshold {
+		return
+	}
+	rateLimitLock.Lock()
+	defer rateLimitLock.Unlock()
+	if time.Now().After(rateLimitedUntil) {
+		warn("[-] Close to the Canvas rate limit (%.0f remaining), pausing requests for %s\n", remaining, RateLimitPause)
+		rateLimitedUntil = time.Now().Add(RateLimitPause)
+	}
+}
+
+// waitForRateLimit sleeps until requests are no longer paused by noteRateLimit
+func waitForRateLimit() {
+	rateLimitLock.Lock()
+	delay := time.Until(rateLimitedUntil)
+	rateLimitLock.Unlock()
+	if delay > 0 {
+		sleep(delay)
+	}
+}
+
+// rateLimited reports whether resp is Canvas rejecting a request for
+// exceeding the rate limit.  The body is left unread.
+func rateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	} else if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return bytes.Contains(body, []byte("Rate Limit Exceeded"))
+}
+
+// retryDelay returns how long to wait before the given retry of a request.
+// The delay doubles with each retry, starting at a second, unless resp has a
+// Retry-After header of seconds or an HTTP date.
+func retryDelay(resp *http.Response, retry int) time.Duration {
+	if resp != nil {
+		after := resp.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(after); err == nil {
+			if delay := time.Until(date); delay > 0 {
+				return delay.Round(time.Second)
+			}
+			return 0
+		}
+	}
+	return time.Duration(1<<uint(retry-1)) * time.Second
+}
+
+func countRetry() {
+	metricsLock.Lock()
+	metrics.Retries++
+	metricsLock.Unlock()
+}
+
+// lookup returns req for a request that only prepares the command, like
+// resolving a title, so -print-endpoint stops at the command's own request
+func (req request) lookup() request {
+	req.PrintEndpoint = false
+	return req
+}
+
+// idempotent reports whether req can be sent again after an error without
+// repeating its effect.  An import that timed out may still have started a
+// migration, so only a rejection for the rate limit is retried for it.
+func (req request) idempotent() bool {
+	return req.Method == "" || req.Method == "GET" || req.Method == "HEAD"
+}
+
+func (req request) retryable(statusCode int) bool {
+	if len(req.RetryStatuses) == 0 {
+		return statusCode >= 500 && statusCode <= 599
+	}
+	for _, code := range req.RetryStatuses {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusCodes parses a comma separated list of HTTP status codes
+func parseStatusCodes(list string) ([]int, error) {
+	var codes []int
+	for _, part := range strings.Split(list, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("\"%s\" is not a valid HTTP status code", part)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+	from string
+}
+
+// Read reports a body that turns out to be corrupt partway through as one that
+// couldn't be decompressed, rather than as a bare "unexpected EOF"
+func (g gzipBody) Read(p []byte) (int, error) {
+	n, err := g.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("could not decompress gzip response from %s: %w", g.from, err)
+	}
+	return n, err
+}
+
+func (g gzipBody) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// decompress replaces a gzip-encoded response body with a decompressing
+// reader.  Go only does this on its own when it sets Accept-Encoding itself,
+// which it doesn't once we've set the header explicitly.  The gzip magic
+// number is checked too, for intermediaries that drop the Content-Encoding
+// header.  from is the URL the response came from, for errors.
+func decompress(resp *http.Response, from string) error {
+	br := bufio.NewReader(resp.Body)
+	magic, _ := br.Peek(2)
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") && !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{br, resp.Body}
+		return nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		resp.Body.Close()
+		return fmt.Errorf("could not decompress gzip response from %s: %w", from, err)
+	}
+	resp.Body = gzipBody{gz, resp.Body, from}
+	resp.Header.Del("Content-Encoding")
+	resp.Uncompressed = true
+	return nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Log levels.  LogQuiet leaves only errors and the final result,
+// LogNoProgress adds the warnings printed with warn, LogNormal the
+// intermediate messages printed with progress, and LogVerbose the requests
+// and responses printed with verbose.
+const (
+	LogQuiet = iota
+	LogNoProgress
+	LogNormal
+	LogVerbose
+)
+
+// logLevel is set by -quiet, -no-progress and -verbose
+var logLevel = LogNormal
+
+// jsonOutput is set by -format json, and makes the results print as JSON
+// with the progress messages on stderr, so stdout can be piped into jq
+var jsonOutput = false
+
+// output is where results are printed, which -output changes to a file
+var output io.Writer = os.Stdout
+
+// csvOutput is set by -format csv, and also moves the progress messages to
+// stderr, so stdout can be redirected to a clean file
+var csvOutput = false
+
+// colorOutput is set when the results are printed to a terminal, and makes
+// the workflow states print in color
+var colorOutput = false
+
+// ANSI escape codes for the colors of the workflow states
+const (
+	ColorRed    = "\033[31m"
+	ColorGreen  = "\033[32m"
+	ColorYellow = "\033[33m"
+	ColorReset  = "\033[0m"
+)
+
+// colorState returns state colored by how it went: green when it's done, red
+// when it failed and yellow while it's still going.  It's returned as it is
+// without colorOutput.
+func colorState(state string) string {
+	if !colorOutput {
+		return state
+	}
+	switch state {
+	case "completed", "imported":
+		return ColorGreen + state + ColorReset
+	case "failed":
+		return ColorRed + state + ColorReset
+	case "running", "queued", "pre_processing", "pre_processed", "exporting", "importing":
+		return ColorYellow + state + ColorReset
+	}
+	return state
+}
+
+func progress(format string, a ...interface{}) {
+	logAt(LogNormal, format, a...)
+}
+
+func verbose(format string, a ...interface{}) {
+	logAt(LogVerbose, format, a...)
+}
+
+// warn prints a warning, which -no-progress doesn't hide
+func warn(format string, a ...interface{}) {
+	logAt(LogNoProgress, format, a...)
+}
+
+// logAt prints a message when the log level is at least level.  Messages go
+// to stderr when stdout is reserved for JSON, CSV, or the -output file.
+func logAt(level int, format string, a ...interface{}) {
+	if logLevel < level {
+		return
+	} else if jsonOutput || csvOutput || output != os.Stdout {
+		fmt.Fprintf(os.Stderr, format, a...)
+	} else {
+		fmt.Printf(format, a...)
+	}
+}
+
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// pickGuid prints a numbered menu of guids and reads the number of the one to
+// import from in, asking again until it gets a valid number.  It returns
+// false if the user quits with 'q' instead.
+func pickGuid(guids []importableGuid, in *bufio.Reader) (string, bool) {
+	fmt.Printf("Outcome frameworks available to import:\n\n")
+	for i, g := range guids {
+		fmt.Printf("%4d. %s\n", i+1, guidTitle(g))
+	}
+	for {
+		fmt.Printf("\n[+] Enter the number of the framework to import, or 'q' to quit: ")
+		answer, err := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if strings.EqualFold(answer, "q") || (err != nil && answer == "") {
+			return "", false
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(guids) {
+			return guids[n-1].Guid, true
+		}
+		fmt.Printf("[-] \"%s\" isn't one of the numbers above\n", answer)
+	}
+}
+
+// promptLock serializes the prompts of concurrent imports, so one's answer
+// can't be read as another's
+var promptLock sync.Mutex
+
+func promptYesNo(question string) bool {
+	// asked on stderr when stdout is for the results
+	logAt(LogQuiet, "%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// readApikey prompts for an API key without echoing it
+func readApikey(prompt string) string {
+	fmt.Print(prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		fatalExit("Unable to read API key:", err)
+	}
+	apikey := strings.TrimSpace(string(b))
+	if apikey == "" {
+		fatalExit("No API key entered")
+	}
+	return apikey
+}
+
+// readApikeyStdin reads an API key from the first line of stdin, prompting
+// for it without echoing when stdin is a terminal
+func readApikeyStdin() string {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return readApikey("[+] Enter your API key: ")
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fatalExit("Unable to read API key:", err)
+	}
+	apikey := strings.TrimSpace(line)
+	if apikey == "" {
+		fatalExit("No API key given on stdin")
+	}
+	return apikey
+}
+
+func promptApikey() string {
+	promptLock.Lock()
+	defer promptLock.Unlock()
+	apikey := readApikey("[+] Enter a new API key (leave blank to give up): ")
+	if promptYesNo("[+] Save this key to the config file?  It is stored in plain-text.") {
+		saveApikey(apikey)
+	}
+	return apikey
+}
+
+// secretStore keeps API keys, by domain, somewhere safer than the config file
+type secretStore interface {
+	Get(domain string) (string, error)
+	Set(domain string, apikey string) error
+}
+
+// newSecretStore returns the OS's secret store: the Keychain on macOS, the
+// Credential Manager on Windows and libsecret on Linux.  The stores are driven
+// through the tools the OS provides for them.
+func newSecretStore() (secretStore, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return macKeychain{}, nil
+	case "windows":
+		return windowsCredentials{}, nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return libsecret{}, nil
+	}
+	return nil, fmt.Errorf("-keychain is not supported on %s", runtime.GOOS)
+}
+
+type macKeychain struct{}
+
+func (macKeychain) Get(domain string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", KeychainName, "-a", domain, "-w").Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (macKeychain) Set(domain string, apikey string) error {
+	// the key is passed hex encoded on stdin so it doesn't show up in ps
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -X %x\n",
+		strconv.Quote(KeychainName), strconv.Quote(domain), apikey))
+	return cmd.Run()
+}
+
+type libsecret struct{}
+
+func (libsecret) Get(domain string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", KeychainName, "domain", domain).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (libsecret) Set(domain string, apikey string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "Outcomes Import Tool API key for "+domain,
+		"service", KeychainName, "domain", domain)
+	cmd.Stdin = strings.NewReader(apikey)
+	return cmd.Run()
+}
+
+type windowsCredentials struct{}
+
+const passwordVault = "[void][Windows.Security.Credentials.PasswordVault,Windows.Security.Credentials,ContentType=WindowsRuntime];" +
+	" $vault = New-Object Windows.Security.Credentials.PasswordVault;"
+
+// powershell runs script with the domain and API key in the environment, so
+// they don't need quoting and the key doesn't show up in the process list
+func (windowsCredentials) powershell(script string, domain string, apikey string) ([]byte, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", passwordVault+script)
+	cmd.Env = append(os.Environ(), "OIT_RESOURCE="+KeychainName, "OIT_DOMAIN="+domain, "OIT_APIKEY="+apikey)
+	return cmd.Output()
+}
+
+func (w windowsCredentials) Get(domain string) (string, error) {
+	out, err := w.powershell("$c = $vault.Retrieve($env:OIT_RESOURCE, $env:OIT_DOMAIN); $c.RetrievePassword(); $c.Password", domain, "")
+	return strings.TrimSpace(string(out)), err
+}
+
+func (w windowsCredentials) Set(domain string, apikey string) error {
+	_, err := w.powershell("$vault.Add((New-Object Windows.Security.Credentials.PasswordCredential("+
+		"$env:OIT_RESOURCE, $env:OIT_DOMAIN, $env:OIT_APIKEY)))", domain, apikey)
+	return err
+}
+
+// keychainApikey returns the API key for domain from the OS keychain.  If it
+// isn't there yet, the user is prompted for it and it's stored.
+func keychainApikey(domain string) string {
+	store, err := newSecretStore()
+	if err != nil {
+		fatalExit(err)
+	}
+	apikey, err := store.Get(domain)
+	if errors.Is(err, exec.ErrNotFound) {
+		fatalExit("The tool needed to access the keychain is not installed:", err)
+	}
+	if err == nil && apikey != "" {
+		progress("[+] Using API key from the keychain\n")
+		return apikey
+	}
+	if !isInteractive() {
+		fatalExit(fmt.Sprintf("There is no API key for %s in the keychain, and no terminal to ask for one", domain))
+	}
+	apikey = readApikey(fmt.Sprintf("[+] Enter the API key for %s to store in the keychain: ", domain))
+	if err := store.Set(domain, apikey); err != nil {
+		fatalExit("Unable to store the API key in the keychain:", err)
+	}
+	progress("[+] API key stored in the keychain\n")
+	return apikey
+}
+
+func printAvailable(req request, format string, filter string, unique bool) {
+	guids, err := getAvailable(&req)
+	if err != nil {
+		requestFailed(err)
+	}
+	guids = filterGuids(guids, filter)
+	if unique {
+		var hidden int
+		if guids, hidden = uniqueTitles(guids); hidden > 0 {
+			progress("[+] Hid %d GUIDs with the same title as another\n", hidden)
+		}
+	}
+	if len(guids) == 0 && filter != "" && format != "json" && format != "csv" {
+		fmt.Fprintf(output, "No available GUIDs have a title containing \"%s\"\n", filter)
+	} else {
+		printImportableGuids(guids, format)
+	}
+	cf := currentConfig()
+	cf.Apikey = req.Apikey
+	cf.Domain = req.Domain
+	cf.writeToFile()
+}
+
+// filterGuids returns the guids with titles containing filter, ignoring case
+func filterGuids(guids []importableGuid, filter string) []importableGuid {
+	if filter == "" {
+		return guids
+	}
+	matching := []importableGuid{}
+	for _, g := range guids {
+		if strings.Contains(strings.ToLower(g.Title), strings.ToLower(filter)) {
+			matching = append(matching, g)
+		}
+	}
+	return matching
+}
+
+// uniqueTitles returns guids without those that have the same title as an
+// earlier one, ignoring case, and the number left out
+func uniqueTitles(guids []importableGuid) ([]importableGuid, int) {
+	seen := map[string]bool{}
+	unique := []importableGuid{}
+	for _, g := range guids {
+		title := strings.ToLower(guidTitle(g))
+		if !seen[title] {
+			seen[title] = true
+			unique = append(unique, g)
+		}
+	}
+	return unique, len(guids) - len(unique)
+}
+
+// getAvailable fetches the available GUIDs and caches them in the config file
+// along with their ETag.  When the cached list came from the same URL, it's
+// used as it is for req.CacheTTL, and after that only fetched again if it has
+// changed.  If Canvas can't be reached, the cached list is used instead.
+func getAvailable(req *request) ([]importableGuid, error) {
+	req.Body = ""
+	req.Method = "GET"
+	req.Endpoint = outcomesImportPath(*req) + "/available"
+	availableUrl := req.Domain + req.Endpoint
+
+	cf := currentConfig()
+	cached := cf.AvailableUrl == availableUrl && len(cf.Guids) > 0
+	if fetchedAt, err := time.Parse(time.RFC3339, cf.AvailableAt); cached && err == nil && time.Since(fetchedAt) < req.CacheTTL {
+		progress("[+] Using the available guids cached at %s.  Use --refresh to check for changes\n", fetchedAt.Local().Format("15:04:05"))
+		return cf.Guids, nil
+	}
+	conditional := *req
+	if cf.AvailableEtag != "" && cached {
+		conditional.Headers = http.Header{}
+		for name, values := range req.Headers {
+			conditional.Headers[name] = values
+		}
+		conditional.Headers.Set("If-None-Match", cf.AvailableEtag)
+	}
+
+	progress("[+] Requesting available guids from %s\n", availableUrl)
+	resp, err := doRequest(&conditional)
+	if err != nil && cached {
+		warn("[-] Unable to request the available guids, using the cached list: %v\n", err)
+		return cf.Guids, nil
+	} else if err != nil {
+		return nil, err
+	}
+	req.Apikey = conditional.Apikey
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		progress("[+] The available guids have not changed, using the cached list\n")
+		updateConfig(func(cf *config) {
+			cf.AvailableAt = time.Now().Format(time.RFC3339)
+		})
+		return cf.Guids, nil
+	}
+	etag := resp.Header.Get("ETag")
+
+	// the list is paginated, and the ETag only covers the first page
+	var guids []importableGuid
+	for {
+		body, err := readResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		var page []importableGuid
+		if e := json.NewDecoder(bytes.NewReader(body)).Decode(&page); e != nil {
+			return nil, fmt.Errorf("JSON decoding error.  Make sure your API key is correct and that you have permission to read global outcomes: %v", e)
+		}
+		guids = append(guids, page...)
+		if req.Endpoint = nextPage(resp); req.Endpoint == "" {
+			break
+		}
+		progress("[+] Requesting more available guids from %s%s\n", req.Domain, req.Endpoint)
+		if resp, err = doRequest(req); err != nil {
+			return nil, err
+		}
+	}
+	guids = dedupeGuids(guids)
+	updateConfig(func(cf *config) {
+		cf.Guids = guids
+		cf.AvailableUrl = availableUrl
+		cf.AvailableEtag = etag
+		cf.AvailableAt = time.Now().Format(time.RFC3339)
+	})
+	return guids, nil
+}
+
+// dedupeGuids drops repeats of a GUID, warning about each, since the server
+// shouldn't list a GUID more than once
+func dedupeGuids(guids []importableGuid) []importableGuid {
+	seen := map[string]bool{}
+	unique := []importableGuid{}
+	for _, g := range guids {
+		key := strings.ToUpper(g.Guid)
+		if seen[key] {
+			warn("[-] The server listed %s (%s) more than once, ignoring the repeat\n", g.Guid, g.Title)
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, g)
+	}
+	return unique
+}
+
+// nextPage returns the request URI of the "next" page from the Link header
+// Canvas sends with paginated responses, or "" if this is the last page.
+func nextPage(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) != `rel="next"` {
+				continue
+			}
+			target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			if u, err := url.Parse(target); err == nil {
+				return u.RequestURI()
+			}
+		}
+	}
+	return ""
+}
+
+// getAllPages requests req.Endpoint and each page after it, passing the body
+// of every page to decodePage.  what describes the items being requested.
+func getAllPages(req *request, what string, decodePage func(body []byte) error) error {
+	req.Body = ""
+	req.Method = "GET"
+	for req.Endpoint != "" {
+		progress("[+] Requesting %s from %s%s\n", what, req.Domain, req.Endpoint)
+		resp, err := doRequest(req)
+		if err != nil {
+			return err
+		}
+		body, err := readResponse(resp)
+		if err != nil {
+			return err
+		}
+		if e := decodePage(body); e != nil {
+			return fmt.Errorf("JSON decoding error.  Make sure your API key is correct and that you have permission to read %s: %v", what, e)
+		}
+		req.Endpoint = nextPage(resp)
+	}
+	return nil
+}
+
+func listAccounts(req request) {
+	req.Endpoint = apiPath(req) + "/accounts?per_page=100"
+	var accounts []account
+	err := getAllPages(&req, "accounts", func(body []byte) error {
+		var page []account
+		err := json.Unmarshal(body, &page)
+		accounts = append(accounts, page...)
+		return err
+	})
+	if err != nil {
+		requestFailed(err)
+	}
+	printAccounts(accounts)
+}
+
+// listMigrations lists the content migrations in state, or all of them when
+// state is empty, with IDs higher than since
+func listMigrations(req request, state string, since int) {
+	req.Endpoint = contentMigrationsPath(req) + "?per_page=100"
+	var migrations []contentMigration
+	err := getAllPages(&req, "migrations", func(body []byte) error {
+		var page []contentMigration
+		err := json.Unmarshal(body, &page)
+		migrations = append(migrations, page...)
+		return err
+	})
+	if err != nil {
+		requestFailed(err)
+	}
+
+	// the content migrations API can't filter by state or ID, so it's done here
+	var matching []contentMigration
+	for _, m := range migrations {
+		if (state == "" || strings.EqualFold(m.WorkflowState, state)) && m.Id > since {
+			matching = append(matching, m)
+		}
+	}
+	migrations = matching
+	printMigrations(migrations)
+	cf := currentConfig()
+	cf.Apikey = req.Apikey
+	cf.Domain = req.Domain
+	cf.writeToFile()
+}
+
+func contentMigrationsPath(req request) string {
+	accountId := req.Account
+	if accountId == "" {
+		// global outcomes are imported into the site admin account
+		accountId = "site_admin"
+	}
+	return fmt.Sprintf("%s/accounts/%s/content_migrations", apiPath(req), url.PathEscape(accountId))
+}
+
+// checkConnection requests the user the API key belongs to, as a cheap way to
+// check the domain and key before doing anything real with them
+func checkConnection(req request) {
+	user, err := fetchSelf(&req)
+	if err != nil {
+		requestFailed(err)
+	}
+	if jsonOutput {
+		printJson(user)
+		return
+	}
+	fmt.Fprintf(output, "[+] Authentication OK, connected to %s as %s\n", req.Domain, user.Name)
+}
+
+// fetchSelf returns the user req's API key belongs to, with an error that says
+// whether it's the domain or the key that's wrong
+func fetchSelf(req *request) (canvasUser, error) {
+	req.Body = ""
+	req.Method = "GET"
+	req.Endpoint = apiPath(*req) + "/users/self"
+	progress("[+] Checking the connection to %s\n", req.Domain)
+	resp, err := doRequest(req)
+	if err != nil {
+		return canvasUser{}, fmt.Errorf("Unable to reach %s, check the domain: %w", req.Domain, err)
+	}
+	body, err := readResponse(resp)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return canvasUser{}, &responseError{resp.StatusCode, fmt.Sprintf("Invalid API key for %s (%s)", req.Domain, resp.Status)}
+	case resp.StatusCode == http.StatusNotFound:
+		return canvasUser{}, &responseError{resp.StatusCode, fmt.Sprintf("%s%s wasn't found, so %s may not be Canvas, or may need -base-path", req.Domain, req.Endpoint, req.Domain)}
+	case err != nil:
+		return canvasUser{}, err
+	}
+	var user canvasUser
+	if e := json.Unmarshal(body, &user); e != nil || user.Id == 0 {
+		return canvasUser{}, &responseError{resp.StatusCode, fmt.Sprintf("%s didn't respond like Canvas, check the domain", req.Domain)}
+	}
+	return user, nil
+}
+
+// cancelMigration deletes the content migration of a scheduled import.  Ones
+// that have already finished are left alone.
+func cancelMigration(req request, migrationId int) {
+	lookup := req.lookup()
+	mstatus, err := fetchStatus(&lookup, migrationId)
+	if err != nil {
+		requestFailed(err)
+	}
+	if isTerminalState(mstatus.WorkflowState) {
+		fatalExit(fmt.Sprintf("Migration %d is already %s, so it can't be cancelled", migrationId, mstatus.WorkflowState))
+	}
+
+	req.Method = "DELETE"
+	req.Endpoint = fmt.Sprintf("%s/%d", contentMigrationsPath(req), migrationId)
+	resp, err := doRequest(&req)
+	if err != nil {
+		requestFailed(err)
+	}
+	if _, err := readResponse(resp); err != nil {
+		requestFailed(err)
+	}
+	if jsonOutput {
+		printJson(cancelledMigration{MigrationId: migrationId, WorkflowState: mstatus.WorkflowState})
+		return
+	}
+	fmt.Fprintf(output, "[+] Cancelled migration %d, which was %s\n", migrationId, mstatus.WorkflowState)
+}
+
+// cancelledMigration is printed for -cancel with -json.  WorkflowState is the
+// state the migration was in when it was cancelled.
+type cancelledMigration struct {
+	MigrationId   int    `json:"migration_id"`
+	WorkflowState string `json:"workflow_state"`
+}
+
+func fetchStatus(req *request, migrationId int) (migrationStatus, error) {
+	req.Body = ""
+	req.Method = "GET"
+	req.Endpoint = fmt.Sprintf(
+		"%s/migration_status/%d",
+		outcomesImportPath(*req),
+		migrationId,
+	)
+
+	progress("[+] Retrieving status for migration %d\n", migrationId)
+	resp, err := doRequest(req)
+	if err != nil {
+		return migrationStatus{}, err
+	}
+	body, err := readResponse(resp)
+	if err != nil {
+		return migrationStatus{}, err
+	}
+
+	var mstatus migrationStatus
+	if e := json.Unmarshal(body, &mstatus); e != nil {
+		return migrationStatus{}, fmt.Errorf("JSON decoding error.  Make sure your API key is correct and that you have permission to read global outcomes: %v", e)
+	}
+	return mstatus, nil
+}
+
+func getStatus(req request, migrationId int) (migrationStatus, error) {
+	mstatus, err := fetchStatus(&req, migrationId)
+	if err != nil {
+		return migrationStatus{}, err
+	}
+	printMigrationStatus(mstatus)
+	cf := currentConfig()
+	cf.Apikey = req.Apikey
+	cf.Domain = req.Domain
+	cf.MigrationId = migrationId
+	cf.setHistoryState(mstatus)
+	cf.writeToFile()
+	return mstatus, nil
+}
+
+// getStatuses checks the status of each of migrationIds, with up to
+// concurrency requests at a time, and prints them in the order given
+func getStatuses(req request, migrationIds []int, concurrency int) []migrationStatus {
+	statuses := make([]migrationStatus, len(migrationIds))
+	errs := make([]error, len(migrationIds))
+	forEachConcurrently(len(migrationIds), concurrency, func(i int) {
+		r := req
+		statuses[i], errs[i] = fetchStatus(&r, migrationIds[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			requestFailed(err)
+		}
+	}
+	cf := currentConfig()
+	for _, mstatus := range statuses {
+		printMigrationStatus(mstatus)
+		cf.setHistoryState(mstatus)
+	}
+	cf.Apikey = req.Apikey
+	cf.Domain = req.Domain
+	cf.MigrationId = migrationIds[len(migrationIds)-1]
+	cf.writeToFile()
+	return statuses
+}
+
+// statusExitCode is the exit code for -status: ExitMigrationFailed if any of
+// the migrations failed, otherwise ExitFailure if any couldn't be found, then
+// ExitMigrationIssues if any have issues, and 0 if none of those.
+func statusExitCode(statuses ...migrationStatus) int {
+	code := 0
+	for _, mstatus := range statuses {
+		switch {
+		case mstatus.WorkflowState == "failed":
+			return ExitMigrationFailed
+		case mstatus.Id == 0:
+			code = ExitFailure
+		case code == 0 && (mstatus.MigrationIssuesCount > 0 || len(mstatus.MigrationIssues) > 0):
+			code = ExitMigrationIssues
+		}
+	}
+	return code
+}
+
+// forEachConcurrently calls fn with each index below count, from a pool of
+// concurrency goroutines, and returns when all the calls have returned
+func forEachConcurrently(count int, concurrency int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// importConcurrently calls importEntry with each of entries from a pool of
+// concurrency workers, and returns the results and exit codes in the order of
+// entries, whatever order the imports finish in
+func importConcurrently(entries []string, concurrency int, isBatch bool, importEntry func(entry string) (batchResult, int)) ([]batchResult, []int) {
+	results := make([]batchResult, len(entries))
+	codes := make([]int, len(entries))
+	forEachConcurrently(len(entries), concurrency, func(i int) {
+		results[i], codes[i] = importEntry(entries[i])
+		if results[i].Error != "" && isBatch {
+			// a batch carries on with the rest after a failure
+			warn("\n[-] %s\n", results[i].Error)
+		}
+	})
+	return results, codes
+}
+
+func compareMigrations(req request, ids string) {
+	parts := strings.Split(ids, ",")
+	if len(parts) != 2 {
+		fatalExit(fmt.Sprintf("\"%s\" must be two migration IDs separated by a comma", ids))
+	}
+	var statuses [2]migrationStatus
+	for i, part := range parts {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			fatalExit(fmt.Sprintf("\"%s\" is not a valid migration ID", part))
+		}
+		if statuses[i], err = fetchStatus(&req, id); err != nil {
+			requestFailed(err)
+		}
+		if statuses[i].Id == 0 {
+			fatalExit(fmt.Sprintf("The server returned an error.  Are you sure migration ID %d exists?", id))
+		}
+	}
+	printMigrationComparison(compareStatuses(statuses[0], statuses[1]))
+}
+
+// issueKey identifies an issue independently of the migration it belongs to,
+// so issues from two different migrations can be matched up.
+func issueKey(issue migrationIssue) string {
+	return fmt.Sprintf("[%s] %s %s", issue.IssueType, issue.Description, issue.ErrorMessage)
+}
+
+var guidPattern = regexp.MustCompile("[0-9A-F]{8}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{12}")
+
+// validGuid reports whether s is a GUID and nothing else: 32 hex digits, in
+// either case, grouped 8-4-4-4-12 with hyphens and without braces
+func validGuid(s string) bool {
+	return len(s) == 36 && guidPattern.MatchString(strings.ToUpper(s))
+}
+
+// guidFromUrl extracts the outcomes GUID embedded in the path, query or
+// fragment of a Canvas URL.
+func guidFromUrl(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("\"%s\" is not a valid URL", rawurl)
+	}
+	rest, err := url.QueryUnescape(u.EscapedPath() + "?" + u.RawQuery + "#" + u.Fragment)
+	if err != nil {
+		rest = u.Path + "?" + u.RawQuery + "#" + u.Fragment
+	}
+	if guid := guidPattern.FindString(strings.ToUpper(rest)); guid != "" {
+		return guid, nil
 	}
-	printMigrationStatus(mstatus)
-	prevConfig := configFromFile()
-	(&config{
-		Apikey:      req.Apikey,
-		Domain:      req.Domain,
-		MigrationId: migrationId,
-		Guids:       prevConfig.Guids,
-	}).writeToFile()
+	return "", fmt.Errorf("No GUID found in \"%s\"", rawurl)
 }
 
-func importGuid(req request, guid string, calcMethod string, calcInt int, masteryPoints int, pointsPossible int, ratings Ratings) {
+// resolveGuid returns guid if it's a proper GUID, or the GUID of the title it matches
+func resolveGuid(req request, guid string) (string, error) {
 	// first check to see if what we've been passed is a proper GUID
+	given := guid
 	guid = strings.ToUpper(guid)
-	match, _ := regexp.MatchString(
-		"[0-9A-F]{8}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{4}-[0-9A-F]{12}",
-		guid,
-	)
 
-	if !match {
-		fmt.Println("[+] GUID is not valid.  Checking to see if it matches a valid title...")
+	if validGuid(guid) {
+		if cached := currentConfig().Guids; len(cached) > 0 && titleForGuid(cached, guid) == "" {
+			warn("[-] %s isn't in the cached list of available GUIDs.  Run tool with --available --refresh to refresh it\n", guid)
+		}
+	} else {
+		progress("[+] GUID is not valid.  Checking to see if it matches a valid title...\n")
 		// then check to see if we've been given a title
-		config := configFromFile()
-		var guids []importableGuid
-		if len(config.Guids) > 0 {
-			fmt.Println("[+] Using cached guid from config file.  Run tool with --available option to force refresh of GUIDs")
-			guids = config.Guids
-		} else {
-			fmt.Println("[+] Cache file does not contain guids.  Fetching guids from AB")
-			guids = getAvailable(req)
+		lookup := req.lookup()
+		guids, err := getAvailable(&lookup)
+		if err != nil {
+			return "", err
+		}
+		if len(guids) == 0 {
+			return "", fmt.Errorf("\"%s\" is not a valid AB GUID, and it can't be matched to a title.  %s", given, NoGuidsAvailable)
 		}
 		found := false
 		for _, val := range guids {
@@ -351,8 +3166,108 @@ func importGuid(req request, guid string, calcMethod string, calcInt int, master
 			}
 		}
 		if !found {
-			fatalExit(fmt.Sprintf("\"%s\" is not a valid AB GUID and it did not match any titles", guid))
+			switch matches := prefixMatches(guids, given); {
+			case len(matches) == 1:
+				progress("[+] \"%s\" is the start of %s (%s)\n", given, guidTitle(matches[0]), matches[0].Guid)
+				return matches[0].Guid, nil
+			case len(matches) > 1:
+				candidates := make([]string, len(matches))
+				for i, g := range matches {
+					candidates[i] = fmt.Sprintf("%s  %s", g.Guid, guidTitle(g))
+				}
+				return "", fmt.Errorf("\"%s\" is ambiguous, it's the start of %d available GUIDs or titles:\n    %s",
+					given, len(matches), strings.Join(candidates, "\n    "))
+			}
+			if similar := similarTitles(guids, given); len(similar) > 0 {
+				return "", fmt.Errorf("\"%s\" is not a valid AB GUID and it did not match any titles.  Did you mean one of these?\n    %s",
+					given, strings.Join(similar, "\n    "))
+			}
+			return "", fmt.Errorf("\"%s\" is not a valid AB GUID and it did not match any titles", given)
+		}
+	}
+	return guid, nil
+}
+
+// prefixMatches returns the guids whose GUID or title starts with prefix,
+// ignoring case, like a short git hash
+func prefixMatches(guids []importableGuid, prefix string) []importableGuid {
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+	var matches []importableGuid
+	if prefix == "" {
+		return matches
+	}
+	for _, g := range guids {
+		if strings.HasPrefix(strings.ToUpper(g.Guid), prefix) || strings.HasPrefix(strings.ToUpper(g.Title), prefix) {
+			matches = append(matches, g)
+		}
+	}
+	return matches
+}
+
+// MaxSimilarTitles is the most titles suggested for one that didn't match
+const MaxSimilarTitles = 5
+
+// similarTitles returns the titles of guids that contain title or are within
+// a few typos of it, ignoring case, closest first
+func similarTitles(guids []importableGuid, title string) []string {
+	title = strings.ToLower(title)
+	type candidate struct {
+		title    string
+		distance int
+	}
+	var candidates []candidate
+	for _, g := range guids {
+		t := guidTitle(g)
+		lower := strings.ToLower(t)
+		distance := editDistance(lower, title)
+		if strings.Contains(lower, title) || distance <= len([]rune(title))/4+1 {
+			candidates = append(candidates, candidate{t, distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	var titles []string
+	for i := 0; i < len(candidates) && i < MaxSimilarTitles; i++ {
+		titles = append(titles, candidates[i].title)
+	}
+	return titles
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
 		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// importGuid schedules the import of guid, which may also be a title.  Errors
+// from Canvas are returned, so that a batch can carry on with the next GUID.
+func importGuid(req request, guid string, calcMethod string, calcInt int, masteryPoints int, pointsPossible int, ratings Ratings) (newImport, error) {
+	guid, err := resolveGuid(req, guid)
+	if err != nil {
+		return newImport{}, err
 	}
 
 	if len(calcMethod) == 0 {
@@ -394,87 +3309,758 @@ func importGuid(req request, guid string, calcMethod string, calcInt int, master
 
 	req.Body = buffer.String()
 	req.Method = "POST"
-	req.Endpoint = "/api/v1/global/outcomes_import/"
+	req.Endpoint = outcomesImportPath(req) + "/"
 
-	client, hreq := httpRequest(req)
+	if req.DryRun {
+		logAt(LogQuiet, "[+] Dry run: would import GUID %s with POST %s%s\n    %s\n", guid, req.Domain, req.Endpoint, req.Body)
+		return newImport{Guid: guid}, nil
+	}
+	if req.Confirm {
+		promptLock.Lock()
+		logAt(LogQuiet, "[+] About to import %s", guid)
+		if title := titleForGuid(currentConfig().Guids, guid); title != "" {
+			logAt(LogQuiet, " (%s)", title)
+		}
+		logAt(LogQuiet, " into %s on %s\n", contextName(req), req.Domain)
+		proceed := promptYesNo("[+] Proceed?")
+		promptLock.Unlock()
+		if !proceed {
+			return newImport{}, fmt.Errorf("The import of %s was cancelled at the prompt", guid)
+		}
+	}
 
-	fmt.Printf("[+] Requesting import of GUID %s\n", guid)
-	resp, err := client.Do(hreq)
+	progress("[+] Requesting import of GUID %s\n", guid)
+	resp, err := doRequest(&req)
 	if err != nil {
-		fatalExit(err)
+		return newImport{}, err
+	}
+	body, err := readResponse(resp)
+	if err != nil {
+		return newImport{}, fmt.Errorf("The import of %s failed: %w", guid, err)
 	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
 
 	var nimport newImport
 	if e := json.NewDecoder(bytes.NewReader(body)).Decode(&nimport); e != nil {
-		fatalExit("JSON decoding error.  Make sure your API key is correct and that you have permission to read global outcomes.", e)
+		return newImport{}, fmt.Errorf("JSON decoding error.  Make sure your API key is correct and that you have permission to read global outcomes: %v", e)
+	}
+	// a response without an ID, e.g. an error we don't recognize, would
+	// otherwise look like a successful import of migration 0
+	if nimport.MigrationId == 0 {
+		return newImport{}, fmt.Errorf("The import of %s failed: the server's response (%s) had no migration ID:\n%s",
+			guid, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if nimport.Guid == "" {
+		nimport.Guid = guid
+	}
+	nimport.Title = titleForGuid(currentConfig().Guids, nimport.Guid)
+
+	if !jsonOutput || !req.InBatch {
+		printImportResults(nimport)
+	}
+	updateConfig(func(cf *config) {
+		cf.Apikey = req.Apikey
+		cf.Domain = req.Domain
+		cf.MigrationId = nimport.MigrationId
+		cf.LastGuid = nimport.Guid
+		cf.History = append(cf.History, historyEntry{
+			MigrationId: nimport.MigrationId,
+			Guid:        nimport.Guid,
+			Title:       nimport.Title,
+			Domain:      req.Domain,
+			ImportedAt:  time.Now(),
+		})
+	})
+	return nimport, nil
+}
+
+func isTerminalState(state string) bool {
+	return state == "completed" || state == "failed" || state == "imported"
+}
+
+// errWatchTimedOut is returned, wrapped, when a watch gives up after its
+// timeout, along with the last status that was seen
+var errWatchTimedOut = errors.New("Timed out waiting for migration")
+
+// watchMigration polls the import until it reaches a terminal workflow state,
+// and returns the final status of its migration.  Canvas's own progress URL is
+// followed when the import response included one, otherwise the migration
+// status is polled.  Each change of state is printed with the time elapsed
+// since watching began, and also written to watchLog when it isn't nil.  After
+// timeout, unless it's 0, the last status is returned with errWatchTimedOut.
+func watchMigration(req request, nimport newImport, interval, timeout time.Duration, watchLog io.Writer) (migrationStatus, error) {
+	migrationId := nimport.MigrationId
+	progress("[+] Watching migration %d, checking every %s\n", migrationId, interval)
+	start := time.Now()
+	lastState := ""
+	for {
+		var mstatus migrationStatus
+		var state string
+		if nimport.ProgressUrl != "" {
+			p, err := fetchProgress(&req, nimport.ProgressUrl)
+			if err != nil {
+				return migrationStatus{}, err
+			}
+			state = p.WorkflowState
+		} else {
+			var err error
+			if mstatus, err = fetchStatus(&req, migrationId); err != nil {
+				return migrationStatus{}, err
+			}
+			state = mstatus.WorkflowState
+		}
+		now := time.Now()
+		if state != lastState {
+			transition := fmt.Sprintf("t+%s %s", formatElapsed(now.Sub(start)), state)
+			if lastState != "" {
+				transition = fmt.Sprintf("t+%s %s → %s", formatElapsed(now.Sub(start)), lastState, state)
+			}
+			progress("[+] %s %s\n", now.Format("15:04:05"), transition)
+			if watchLog != nil {
+				fmt.Fprintf(watchLog, "%s migration %d %s\n", now.Format(time.RFC3339), migrationId, transition)
+			}
+			lastState = state
+		} else {
+			progress("[+] %s t+%s still %s\n", now.Format("15:04:05"), formatElapsed(now.Sub(start)), state)
+		}
+		if nimport.ProgressUrl == "" && (mstatus.Id == 0 || isTerminalState(state)) {
+			return mstatus, nil
+		}
+		if nimport.ProgressUrl != "" && isTerminalState(state) {
+			// the progress only carries the state, the issues come from the migration
+			return fetchStatus(&req, migrationId)
+		}
+		if timeout > 0 && now.Sub(start) >= timeout {
+			if nimport.ProgressUrl != "" {
+				var err error
+				if mstatus, err = fetchStatus(&req, migrationId); err != nil {
+					return migrationStatus{}, err
+				}
+			}
+			return mstatus, fmt.Errorf("%w %d, which is still %s after %s", errWatchTimedOut, migrationId, state, timeout)
+		}
+		sleep(interval)
+	}
+}
+
+// watchMigrations polls several migrations until they have all reached a
+// terminal workflow state, and returns their final statuses.  Each round
+// checks the ones still running, concurrency at a time.  After timeout, unless
+// it's 0, the last statuses are returned with errWatchTimedOut.
+func watchMigrations(req request, migrationIds []int, concurrency int, interval, timeout time.Duration, watchLog io.Writer) ([]migrationStatus, error) {
+	progress("[+] Watching %d migrations, checking every %s\n", len(migrationIds), interval)
+	start := time.Now()
+	statuses := make([]migrationStatus, len(migrationIds))
+	for {
+		var running []int
+		for i, mstatus := range statuses {
+			if mstatus.Id == 0 || !isTerminalState(mstatus.WorkflowState) {
+				running = append(running, i)
+			}
+		}
+		previous := make([]string, len(statuses))
+		for i, mstatus := range statuses {
+			previous[i] = mstatus.WorkflowState
+		}
+		errs := make([]error, len(running))
+		forEachConcurrently(len(running), concurrency, func(i int) {
+			r := req
+			statuses[running[i]], errs[i] = fetchStatus(&r, migrationIds[running[i]])
+		})
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		now := time.Now()
+		finished := 0
+		for i, mstatus := range statuses {
+			if mstatus.Id == 0 {
+				return nil, fmt.Errorf("Migration %d could not be found", migrationIds[i])
+			}
+			if isTerminalState(mstatus.WorkflowState) {
+				finished++
+			}
+			if mstatus.WorkflowState != previous[i] {
+				transition := fmt.Sprintf("t+%s migration %d %s", formatElapsed(now.Sub(start)), mstatus.Id, mstatus.WorkflowState)
+				if previous[i] != "" {
+					transition = fmt.Sprintf("t+%s migration %d %s → %s", formatElapsed(now.Sub(start)), mstatus.Id, previous[i], mstatus.WorkflowState)
+				}
+				progress("[+] %s %s\n", now.Format("15:04:05"), transition)
+				if watchLog != nil {
+					fmt.Fprintf(watchLog, "%s %s\n", now.Format(time.RFC3339), transition)
+				}
+			}
+		}
+		if finished == len(statuses) {
+			return statuses, nil
+		}
+		progress("[+] %s t+%s %d of %d migrations finished\n", now.Format("15:04:05"), formatElapsed(now.Sub(start)), finished, len(statuses))
+		if timeout > 0 && now.Sub(start) >= timeout {
+			return statuses, fmt.Errorf("%w, %d of %d are still running after %s", errWatchTimedOut, len(statuses)-finished, len(statuses), timeout)
+		}
+		sleep(interval)
+	}
+}
+
+// saveErrorReports downloads the error report of each of the statuses' issues
+// that has one into dir, as migration-<id>-issue-<id>.html.  Nothing is done
+// when dir is empty.
+func saveErrorReports(req request, dir string, statuses ...migrationStatus) {
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fatalExit("Unable to create the error report directory:", err)
+	}
+	saved, failed := 0, 0
+	for _, mstatus := range statuses {
+		for _, issue := range mstatus.MigrationIssues {
+			if issue.ErrorReportUrl == "" {
+				continue
+			}
+			path := filepath.Join(dir, fmt.Sprintf("migration-%d-issue-%d.html", mstatus.Id, issue.Id))
+			if err := saveErrorReport(req, issue.ErrorReportUrl, path); err != nil {
+				fmt.Fprintf(os.Stderr, "[-] Unable to save the error report of issue %d: %v\n", issue.Id, err)
+				failed++
+				continue
+			}
+			saved++
+		}
+	}
+	progress("[+] Saved %d error reports to %s\n", saved, dir)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "[-] %d error reports could not be saved\n", failed)
+	}
+}
+
+// saveErrorReport writes the error report at reportUrl to path.  Reports are
+// HTML pages, so the body isn't read as JSON.
+func saveErrorReport(req request, reportUrl string, path string) error {
+	u, err := url.Parse(reportUrl)
+	if err != nil {
+		return fmt.Errorf("Invalid error report URL \"%s\": %v", reportUrl, err)
+	}
+	req.Body = ""
+	req.Method = "GET"
+	req.Endpoint = u.RequestURI()
+
+	progress("[+] Downloading error report %s\n", reportUrl)
+	resp, err := doRequest(&req)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fetchProgress(req *request, progressUrl string) (progressStatus, error) {
+	u, err := url.Parse(progressUrl)
+	if err != nil {
+		return progressStatus{}, fmt.Errorf("Invalid progress URL \"%s\": %v", progressUrl, err)
+	}
+	req.Body = ""
+	req.Method = "GET"
+	req.Endpoint = u.RequestURI()
+
+	progress("[+] Retrieving progress from %s\n", progressUrl)
+	resp, err := doRequest(req)
+	if err != nil {
+		return progressStatus{}, err
+	}
+	body, err := readResponse(resp)
+	if err != nil {
+		return progressStatus{}, err
+	}
+
+	var p progressStatus
+	if e := json.Unmarshal(body, &p); e != nil {
+		return progressStatus{}, fmt.Errorf("JSON decoding error.  Make sure your API key is correct and that you have permission to read the import's progress: %v", e)
+	}
+	return p, nil
+}
+
+// formatElapsed formats d as hh:mm:ss
+func formatElapsed(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// verifyImport reports whether the outcome group created by nimport can be
+// read back.  The GUID's title is also accepted as a match, since not every
+// group carries the GUID as its vendor_guid.
+func verifyImport(req request, nimport newImport) (bool, error) {
+	progress("[+] Verifying the import of %s\n", nimport.Guid)
+	title := nimport.Title
+	if title == "" {
+		lookup := req.lookup()
+		if guids, err := getAvailable(&lookup); err == nil {
+			title = titleForGuid(guids, nimport.Guid)
+		}
+	}
+	var root outcomeGroup
+	req.Endpoint = contextPath(req) + "/root_outcome_group"
+	err := getAllPages(&req, "the root outcome group", func(body []byte) error {
+		return json.Unmarshal(body, &root)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var groups []outcomeGroup
+	req.Endpoint = fmt.Sprintf("%s/outcome_groups/%d/subgroups?per_page=100", contextPath(req), root.Id)
+	err = getAllPages(&req, "outcome groups", func(body []byte) error {
+		var page []outcomeGroup
+		err := json.Unmarshal(body, &page)
+		groups = append(groups, page...)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		if strings.EqualFold(group.VendorGuid, nimport.Guid) || (title != "" && strings.EqualFold(group.Title, title)) {
+			progress("\n[+] Verified: outcome group %d \"%s\" is present\n", group.Id, group.Title)
+			return true, nil
+		}
 	}
-	if nimport.MigrationId == 0 && len(nimport.Errors) == 0 {
-		fmt.Println("[-] Ruh-roh, server error:\n", string(body))
-		os.Exit(1)
+	return false, nil
+}
+
+// failedOnlyWithIssueType reports whether a finished migration failed and every
+// one of its issues is of the given type.
+func failedOnlyWithIssueType(mstatus migrationStatus, issueType string) bool {
+	if issueType == "" || mstatus.WorkflowState != "failed" || len(mstatus.MigrationIssues) == 0 {
+		return false
+	}
+	for _, issue := range mstatus.MigrationIssues {
+		if issue.IssueType != issueType {
+			return false
+		}
 	}
+	return true
+}
 
-	printImportResults(nimport)
-	prevConfig := configFromFile()
-	(&config{
-		Apikey:      req.Apikey,
-		Domain:      req.Domain,
-		MigrationId: nimport.MigrationId,
-		Guids:       prevConfig.Guids,
-	}).writeToFile()
+// finalStatusError describes why a finished migration is a failure, or returns
+// "" if it isn't.  With strict, finishing with any migration issues at all is
+// a failure.
+func finalStatusError(mstatus migrationStatus, strict bool) string {
+	if mstatus.Id == 0 {
+		return "The migration could not be found"
+	}
+	if mstatus.WorkflowState == "failed" {
+		return fmt.Sprintf("Migration %d failed", mstatus.Id)
+	}
+	// the count and the list of issues aren't always both filled in
+	issues := mstatus.MigrationIssuesCount
+	if len(mstatus.MigrationIssues) > issues {
+		issues = len(mstatus.MigrationIssues)
+	}
+	if strict && issues > 0 {
+		return fmt.Sprintf("Migration %d finished with %d issue(s), which is not allowed with -strict", mstatus.Id, issues)
+	}
+	return ""
 }
 
-func printImportableGuids(guids []importableGuid) {
-	fmt.Printf("GUIDs available to import:\n\n")
+const NoGuidsAvailable = "No outcome frameworks are available to import on this domain."
+
+// printImportableGuids prints guids sorted by title, ignoring case
+func printImportableGuids(guids []importableGuid, format string) {
+	guids = append([]importableGuid{}, guids...)
+	sort.SliceStable(guids, func(i, j int) bool {
+		return strings.ToLower(guidTitle(guids[i])) < strings.ToLower(guidTitle(guids[j]))
+	})
+	if format == "json" {
+		printJson(guids)
+		return
+	}
+	if format == "csv" {
+		records := [][]string{{"guid", "title"}}
+		for _, guid := range guids {
+			records = append(records, []string{guid.Guid, guidTitle(guid)})
+		}
+		printCsv(records)
+		return
+	}
+	if len(guids) == 0 {
+		fmt.Fprintln(output, NoGuidsAvailable)
+		return
+	}
+	if format == "markdown" {
+		fmt.Fprintln(output, "| GUID | Title |")
+		fmt.Fprintln(output, "| --- | --- |")
+	} else {
+		fmt.Fprintf(output, "GUIDs available to import:\n\n")
+	}
 	for _, guid := range guids {
-		if guid.Title == "" {
-			fmt.Printf("%s - %s\n", guid.Guid, guid.Description)
+		title := guidTitle(guid)
+		if format == "markdown" {
+			fmt.Fprintf(output, "| %s | %s |\n", escapeMarkdown(guid.Guid), escapeMarkdown(title))
 		} else {
-			fmt.Printf("%s - %s\n", guid.Guid, guid.Title)
+			printRow(guid.Guid, title)
 		}
 	}
 }
 
-func printMigrationStatus(mstatus migrationStatus) {
-	if len(mstatus.Errors) > 0 {
-		printErrors(mstatus.Errors)
+// guidTitle returns the title of guid, or its description when it has none
+func guidTitle(guid importableGuid) string {
+	if guid.Title == "" {
+		return guid.Description
+	}
+	return guid.Title
+}
+
+func printCsv(records [][]string) {
+	w := csv.NewWriter(output)
+	if err := w.WriteAll(records); err != nil {
+		fatalExit("CSV encoding error:", err)
+	}
+}
+
+func printJson(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fatalExit("JSON encoding error:", err)
+	}
+	fmt.Fprintln(output, string(b))
+}
+
+func printBatchSummary(summary *batchSummary, format string) {
+	if format == "json" {
+		printJson(summary)
+		return
+	}
+	if format == "csv" {
+		records := [][]string{{"entry", "guid", "migration_id", "succeeded", "error"}}
+		for _, r := range summary.Results {
+			records = append(records, []string{r.Entry, r.Guid, strconv.Itoa(r.MigrationId), strconv.FormatBool(r.Succeeded), r.Error})
+		}
+		printCsv(records)
+		return
+	}
+	elapsed := formatElapsed(time.Duration(summary.ElapsedSeconds * float64(time.Second)))
+	if format == "markdown" {
+		fmt.Fprintln(output, "| Entry | GUID | Migration ID | Result |")
+		fmt.Fprintln(output, "| --- | --- | --- | --- |")
 	} else {
-		if mstatus.Id == 0 {
-			fmt.Println("\nThe server returned an error.  Are you sure that migration ID exists?")
+		fmt.Fprintf(output, "\nBatch import summary:\n\n")
+	}
+	for _, r := range summary.Results {
+		result := "succeeded"
+		if !r.Succeeded {
+			result = r.Error
+		}
+		if format == "markdown" {
+			fmt.Fprintf(output, "| %s | %s | %d | %s |\n", escapeMarkdown(r.Entry), escapeMarkdown(r.Guid), r.MigrationId, escapeMarkdown(result))
 		} else {
-			fmt.Printf("\nMigration status for migration '%d':\n", mstatus.Id)
-			fmt.Printf(" - Workflow state: %s\n", mstatus.WorkflowState)
-			fmt.Printf(" - Migration issues count: %d\n", mstatus.MigrationIssuesCount)
-			fmt.Printf(" - Migration issues:\n")
-			for _, val := range mstatus.MigrationIssues {
-				fmt.Printf("   - ID: %d\n", val.Id)
-				fmt.Printf("   - Link: %s\n", val.ErrorReportUrl)
-				fmt.Printf("   - Issue type: %s\n", val.IssueType)
-				fmt.Printf("   - Error message: %s\n", val.ErrorMessage)
-				fmt.Printf("   - Description: %s\n", val.Description)
+			fmt.Fprintf(output, " - %s (%s): migration %d, %s\n", r.Entry, r.Guid, r.MigrationId, result)
+		}
+	}
+	fmt.Fprintf(output, "\n%d attempted, %d succeeded, %d failed, %d skipped in %s\n",
+		summary.Attempted, summary.Succeeded, summary.Failed, summary.Skipped, elapsed)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`",
+	"[", "\\[", "]", "\\]", "<", "\\<", ">", "\\>", "#", "\\#",
+	"\r\n", " ", "\n", " ",
+)
+
+// escapeMarkdown escapes s so it can be used as a cell in a Markdown table.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// DefaultDelimiter separates the fields of tabular output for humans
+const DefaultDelimiter = " - "
+
+// delimiter separates the fields of tabular output, and is set by -delimiter
+var delimiter = DefaultDelimiter
+
+// formatRow joins fields with delimiter.  With a -delimiter, fields containing
+// it or a double quote are quoted, with double quotes doubled, as in CSV.
+func formatRow(fields ...interface{}) string {
+	cells := make([]string, len(fields))
+	for i, field := range fields {
+		cell := fmt.Sprint(field)
+		if delimiter != DefaultDelimiter && (strings.Contains(cell, delimiter) || strings.Contains(cell, `"`)) {
+			cell = `"` + strings.Replace(cell, `"`, `""`, -1) + `"`
+		}
+		cells[i] = cell
+	}
+	return strings.Join(cells, delimiter)
+}
+
+func printRow(fields ...interface{}) {
+	fmt.Fprintln(output, formatRow(fields...))
+}
+
+func printAccounts(accounts []account) {
+	fmt.Fprintf(output, "Accounts available to you:\n\n")
+	for _, a := range accounts {
+		printRow(a.Id, a.Name)
+	}
+}
+
+func printMigrations(migrations []contentMigration) {
+	if len(migrations) == 0 {
+		fmt.Fprintln(output, "\nNo migrations found")
+		return
+	}
+	fmt.Fprintf(output, "\nMigrations:\n\n")
+	for _, m := range migrations {
+		printRow(m.Id, m.WorkflowState, m.CreatedAt)
+	}
+}
+
+// wrapWidth is the width long fields are wrapped to, or 0 to not wrap them
+var wrapWidth = 0
+
+// DefaultWrapWidth is used when stdout isn't a terminal
+const DefaultWrapWidth = 80
+
+func terminalWidth() int {
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+	return DefaultWrapWidth
+}
+
+// wrap word-wraps text, which follows indent columns of other text, to
+// wrapWidth.  Continuation lines are indented to line up with the first.
+func wrap(text string, indent int) string {
+	if wrapWidth == 0 {
+		return text
+	}
+	var b strings.Builder
+	column := indent
+	for i, word := range strings.Fields(text) {
+		if i > 0 {
+			if column+1+len(word) > wrapWidth {
+				b.WriteString("\n" + strings.Repeat(" ", indent))
+				column = indent
+			} else {
+				b.WriteString(" ")
+				column++
 			}
 		}
+		b.WriteString(word)
+		column += len(word)
 	}
+	return b.String()
 }
 
-func printImportResults(nimport newImport) {
-	fmt.Println(nimport)
-	if len(nimport.Errors) > 0 {
-		printErrors(nimport.Errors)
-	} else if nimport.Error != "" {
-		fmt.Printf("\n[-] Error: %s\n", nimport.Error)
+func printMigrationStatus(mstatus migrationStatus) {
+	if jsonOutput {
+		printJson(mstatus)
+		return
+	}
+	if mstatus.Id == 0 {
+		fmt.Fprintln(output, "\nThe server returned an error.  Are you sure that migration ID exists?")
 	} else {
-		fmt.Printf("\n[+] Migration ID is %d\n", nimport.MigrationId)
+		switch mstatus.WorkflowState {
+		case "failed":
+			fmt.Fprintf(output, "\n❌ Migration %d FAILED\n", mstatus.Id)
+		case "completed", "imported":
+			fmt.Fprintf(output, "\n✅ Migration %d completed successfully\n", mstatus.Id)
+		default:
+			fmt.Fprintf(output, "\n⏳ Migration %d is still %s\n", mstatus.Id, colorState(mstatus.WorkflowState))
+		}
+		fmt.Fprintf(output, "\nMigration status for migration '%d':\n", mstatus.Id)
+		fmt.Fprintf(output, " - Workflow state: %s\n", colorState(mstatus.WorkflowState))
+		if mstatus.CreatedAt != "" {
+			fmt.Fprintf(output, " - Created at: %s\n", mstatus.CreatedAt)
+		}
+		if mstatus.FinishedAt != "" {
+			fmt.Fprintf(output, " - Finished at: %s\n", mstatus.FinishedAt)
+		} else if mstatus.UpdatedAt != "" {
+			fmt.Fprintf(output, " - Updated at: %s\n", mstatus.UpdatedAt)
+		}
+		if took, ok := migrationDuration(mstatus); ok {
+			fmt.Fprintf(output, " - Duration: %s\n", formatElapsed(took))
+		}
+		fmt.Fprintf(output, " - Migration issues count: %d\n", mstatus.MigrationIssuesCount)
+		if len(mstatus.MigrationIssues) > 0 {
+			fmt.Fprintf(output, " - Migration issues:\n")
+		}
+		for _, group := range groupIssues(mstatus.MigrationIssues) {
+			issueType := group[0].IssueType
+			if issueType == "" {
+				issueType = "unknown"
+			}
+			fmt.Fprintf(output, "   - %s (%d):\n", issueType, len(group))
+			for _, val := range group {
+				fmt.Fprintf(output, "     - ID: %d\n", val.Id)
+				fmt.Fprintf(output, "       Error message: %s\n", wrap(val.ErrorMessage, len("       Error message: ")))
+				fmt.Fprintf(output, "       Description: %s\n", wrap(truncate(val.Description, MaxIssueDescription), len("       Description: ")))
+				if val.ErrorReportUrl != "" {
+					fmt.Fprintf(output, "       Link: %s\n", val.ErrorReportUrl)
+				}
+			}
+		}
+		fmt.Fprintf(output, "\n%s\n", statusSummary(mstatus))
+	}
+}
+
+// statusSummary is a one-line takeaway of a migration's status, printed last
+// so it's easy to find in logs
+func statusSummary(mstatus migrationStatus) string {
+	issues := "issues"
+	if mstatus.MigrationIssuesCount == 1 {
+		issues = "issue"
+	}
+	if isTerminalState(mstatus.WorkflowState) {
+		return fmt.Sprintf("Migration %d: %s with %d %s", mstatus.Id, mstatus.WorkflowState, mstatus.MigrationIssuesCount, issues)
+	}
+	return fmt.Sprintf("Migration %d: %s, %d %s so far", mstatus.Id, mstatus.WorkflowState, mstatus.MigrationIssuesCount, issues)
+}
+
+// migrationDuration returns how long a finished migration took, from its
+// creation until it finished, or was last updated when Canvas doesn't say when
+// it finished.  ok is false while it's running or when a timestamp is missing.
+func migrationDuration(mstatus migrationStatus) (took time.Duration, ok bool) {
+	end := mstatus.FinishedAt
+	if end == "" && isTerminalState(mstatus.WorkflowState) {
+		end = mstatus.UpdatedAt
+	}
+	createdAt, err := time.Parse(time.RFC3339, mstatus.CreatedAt)
+	if err != nil {
+		return 0, false
+	}
+	finishedAt, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return 0, false
+	}
+	return finishedAt.Sub(createdAt), true
+}
+
+// MaxIssueDescription is the most of a migration issue's description that's
+// printed, since some include whole documents
+const MaxIssueDescription = 300
+
+// truncate shortens s to max characters, marking that it was cut
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max]) + "... (truncated)"
+}
+
+// groupIssues groups issues by their type, in the order each type first
+// appears
+func groupIssues(issues []migrationIssue) [][]migrationIssue {
+	var groups [][]migrationIssue
+	index := map[string]int{}
+	for _, issue := range issues {
+		i, ok := index[issue.IssueType]
+		if !ok {
+			i = len(groups)
+			index[issue.IssueType] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], issue)
+	}
+	return groups
+}
+
+// migrationComparison is the difference between two migrations, as compared
+// by -compare.  Each pair holds the first migration's value, then the second's.
+type migrationComparison struct {
+	Migrations            [2]int      `json:"migrations"`
+	WorkflowStates        [2]string   `json:"workflow_states"`
+	MigrationIssuesCounts [2]int      `json:"migration_issues_counts"`
+	IssuesInBoth          int         `json:"issues_in_both"`
+	IssuesOnlyIn          [2][]string `json:"issues_only_in"`
+	Equivalent            bool        `json:"equivalent"`
+}
+
+// compareStatuses matches up the issues of a and b by issueKey
+func compareStatuses(a, b migrationStatus) migrationComparison {
+	c := migrationComparison{
+		Migrations:            [2]int{a.Id, b.Id},
+		WorkflowStates:        [2]string{a.WorkflowState, b.WorkflowState},
+		MigrationIssuesCounts: [2]int{a.MigrationIssuesCount, b.MigrationIssuesCount},
+		IssuesOnlyIn:          [2][]string{{}, {}},
+	}
+	inA := map[string]bool{}
+	for _, issue := range a.MigrationIssues {
+		inA[issueKey(issue)] = true
+	}
+	inB := map[string]bool{}
+	for _, issue := range b.MigrationIssues {
+		inB[issueKey(issue)] = true
+	}
+	for key := range inA {
+		if inB[key] {
+			c.IssuesInBoth++
+		}
+	}
+	for i, pair := range []struct {
+		status migrationStatus
+		other  map[string]bool
+	}{{a, inB}, {b, inA}} {
+		for _, issue := range pair.status.MigrationIssues {
+			if !pair.other[issueKey(issue)] {
+				c.IssuesOnlyIn[i] = append(c.IssuesOnlyIn[i], issueKey(issue))
+			}
+		}
+	}
+	c.Equivalent = a.WorkflowState == b.WorkflowState && c.IssuesInBoth == len(inA) && c.IssuesInBoth == len(inB)
+	return c
+}
+
+func printMigrationComparison(c migrationComparison) {
+	if jsonOutput {
+		printJson(c)
+		return
+	}
+	marker := func(same bool) string {
+		if same {
+			return ""
+		}
+		return "  (differs)"
+	}
+	fmt.Fprintf(output, "\nComparison of migrations '%d' and '%d':\n", c.Migrations[0], c.Migrations[1])
+	fmt.Fprintf(output, " - Workflow state: %s | %s%s\n", c.WorkflowStates[0], c.WorkflowStates[1],
+		marker(c.WorkflowStates[0] == c.WorkflowStates[1]))
+	fmt.Fprintf(output, " - Migration issues count: %d | %d%s\n", c.MigrationIssuesCounts[0], c.MigrationIssuesCounts[1],
+		marker(c.MigrationIssuesCounts[0] == c.MigrationIssuesCounts[1]))
+	fmt.Fprintf(output, " - Issues in both: %d\n", c.IssuesInBoth)
+	for i, id := range c.Migrations {
+		fmt.Fprintf(output, " - Issues only in '%d':\n", id)
+		for _, key := range c.IssuesOnlyIn[i] {
+			fmt.Fprintf(output, "   - %s\n", key)
+		}
+	}
+	if c.Equivalent {
+		fmt.Fprintln(output, "\n[+] The migrations are equivalent")
+	} else {
+		fmt.Fprintln(output, "\n[-] The migrations differ")
 	}
 }
 
-func printErrors(errors []apiError) {
-	fmt.Println("\n[-] Errors encountered:")
-	for _, err := range errors {
-		fmt.Println(err)
+func printImportResults(nimport newImport) {
+	if jsonOutput {
+		printJson(nimport)
+		return
+	}
+	if nimport.Title == "" {
+		fmt.Fprintf(output, "\n[+] Scheduled import of %s as migration %d\n", nimport.Guid, nimport.MigrationId)
+		return
 	}
+	fmt.Fprintf(output, "\n[+] Scheduled import of '%s' (%s) as migration %d\n", nimport.Title, nimport.Guid, nimport.MigrationId)
 }
 
 func printHelp() {
@@ -499,7 +4085,9 @@ To install:
 Usage is simple.  You must provide the tool with a Canvas API key, and then tell it
 what to do.  The default action is to check the status of the most recent import.
 OIT knows the Migration ID of the most recent import because it saves it in a json
-file located at $HOME/outcomes-import-tool.json.
+file located at $XDG_CONFIG_HOME/outcomes-import-tool/config.json (by default
+~/.config/outcomes-import-tool/config.json), or wherever -config says.  An existing
+$HOME/.outcomes-import-tool.json from an older version is still used.
 
 You must also provide it with a Canvas domain.  For a school that has
 "<school-name>.instructure.com", you can simply provide the school name.  You can also
@@ -520,6 +4108,13 @@ Example to check status with specified ID of 35 (which becomes the new default)
 
     outcomes-import-tool --apikey="MyKey" --status 35
 
+Imports can be given a friendly name with --name, which records the migration ID
+and domain in $HOME/.outcomes-import-tool-index.json.  Passing --name without
+--guid checks the status of the import recorded under that name:
+
+    outcomes-import-tool --apikey="MyKey" --guid "Iowa" --name "spring-2024-math"
+    outcomes-import-tool --apikey="MyKey" --name "spring-2024-math"
+
 Example to import a GUID.  This can be specified by Title from the list of available
 GUIDs, or by GUID itself.  By title for Iowa standards:
 
@@ -529,10 +4124,41 @@ By GUID:
 
     outcomes-import-tool --apikey="MyKey" --guid "A832FC24-901A-11DF-A622-0C319DFF4B22"
 
+Several GUIDs (or titles) can be imported at once by separating them with commas.
+Progress is recorded in $HOME/.outcomes-import-tool-batch.json, so if the tool is
+interrupted you can re-run the same command with --resume to skip the ones that
+were already imported:
+
+    outcomes-import-tool --apikey="MyKey" --guid "Iowa,Utah,Texas" --resume
+
+Add --watch to wait for the import to finish.  The tool exits non-zero if the
+migration fails, or with --strict, if it finishes with any migration issues:
+
+    outcomes-import-tool --apikey="MyKey" --guid "Iowa" --watch --strict
+
 Example to list available GUIDs and their Titles:
 
     outcomes-import-tool --apikey="MyKey" --available
 
+Add --format markdown to print them as a Markdown table instead.
+
+Example to list the IDs and names of the accounts you have access to:
+
+    outcomes-import-tool --apikey="MyKey" --list-accounts
+
+Any of the commands can be scoped to an account instead of the global outcomes
+by passing --account with its ID.  The account is remembered as "default_account"
+in the json file, so later commands stay scoped to it until you pass --global (or
+set "default_scope" to "global").
+
+Example to list the migrations that failed:
+
+    outcomes-import-tool --apikey="MyKey" --list-migrations --state failed
+
+Example to compare the results of migrations 35 and 42 (e.g. after a re-import):
+
+    outcomes-import-tool --apikey="MyKey" --compare-migrations 35,42
+
 If you want, you can put your API key in the json file and you won't have to specify
 it each time.  Be advised though, *this file is stored in plain-text in your home
 directory*.  Use this for test instances of Canvas, but *it is not safe to do so with